@@ -0,0 +1,278 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrOutranked = errors.New("that account has roles or permissions you don't hold")
+
+type UserQuery struct {
+	// Matches users whose username or email contains Search, ignoring case
+	Search         string
+	IncludeDeleted bool
+	Skip           int
+	// Zero means no limit
+	Limit int
+}
+
+// ListUsers returns the users matching q, sorted by username.
+func ListUsers(q UserQuery, c *Context) (users []*User, err error) {
+	query := bson.M{}
+
+	if q.Search != "" {
+		pattern := bson.RegEx{Pattern: regexp.QuoteMeta(strings.ToLower(q.Search))}
+		query["$or"] = []bson.M{
+			{"username_normalized": pattern},
+			{"email": pattern},
+		}
+	}
+
+	if !q.IncludeDeleted {
+		query = notDeleted(query)
+	}
+
+	err = c.Database.C("goat_users").Find(query).Sort("username_normalized").
+		Skip(q.Skip).Limit(q.Limit).All(&users)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		if err = c.decodeProfile(u); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// RegisterAdminRoutes mounts a JSON API for managing users under prefix.
+// Requests must come from a logged in user with one of the given roles,
+// "admin" if none are given. POST and DELETE requests must also send the
+// session's CSRF token in the X-CSRF-Token header, see Context.CSRFToken,
+// and bodies must be JSON.
+//
+//	GET    <prefix>/users?q=&skip=&limit=&deleted=true
+//	POST   <prefix>/users                      {"username", "password", "email", "roles"}
+//	GET    <prefix>/users/{username}
+//	DELETE <prefix>/users/{username}[?purge=true]
+//	POST   <prefix>/users/{username}/disable
+//	POST   <prefix>/users/{username}/enable
+//	POST   <prefix>/users/{username}/restore
+//	POST   <prefix>/users/{username}/reset-token
+//
+// Deleted users are only listed with deleted=true, but can be fetched,
+// restored and purged by name. Admins can't create or act on users with
+// roles or permissions they don't hold themselves.
+func (g *Goat) RegisterAdminRoutes(prefix string, roles ...string) {
+	if len(roles) == 0 {
+		roles = []string{"admin"}
+	}
+
+	prefix = strings.TrimRight(prefix, "/")
+
+	admin := func(h Handler) []Interceptor {
+		return []Interceptor{
+			NewAuthSessionInterceptor(nil, Generic401),
+			NewCSRFInterceptor(nil, nil),
+			NewRequireRoleInterceptor(roles, h, nil),
+		}
+	}
+
+	users := prefix + "/users"
+	user := users + "/{username}"
+
+	g.RegisterRoute(users, "goat_admin_list_users", GET, admin(adminListUsers))
+	g.RegisterRoute(users, "goat_admin_create_user", POST, admin(adminCreateUser))
+	g.RegisterRoute(user, "goat_admin_get_user", GET, admin(adminUser(adminGetUser)))
+	g.RegisterRoute(user, "goat_admin_delete_user", DELETE, admin(adminUser(adminDeleteUser)))
+	g.RegisterRoute(user+"/disable", "goat_admin_disable_user", POST, admin(adminUser(adminDisableUser)))
+	g.RegisterRoute(user+"/enable", "goat_admin_enable_user", POST, admin(adminUser(adminEnableUser)))
+	g.RegisterRoute(user+"/restore", "goat_admin_restore_user", POST, admin(adminUser(adminRestoreUser)))
+	g.RegisterRoute(user+"/reset-token", "goat_admin_reset_token", POST, admin(adminUser(adminResetToken)))
+}
+
+func adminListUsers(w http.ResponseWriter, r *http.Request, c *Context) error {
+	q := UserQuery{
+		Search:         r.FormValue("q"),
+		IncludeDeleted: r.FormValue("deleted") == "true",
+		Limit:          100,
+	}
+
+	q.Skip, _ = strconv.Atoi(r.FormValue("skip"))
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil {
+		q.Limit = limit
+	}
+
+	users, err := ListUsers(q, c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
+
+func adminCreateUser(w http.ResponseWriter, r *http.Request, c *Context) error {
+	var body struct {
+		Username string   `json:"username"`
+		Password string   `json:"password"`
+		Email    string   `json:"email"`
+		Roles    []string `json:"roles"`
+	}
+
+	// Browsers can't send JSON across sites without CORS allowing it,
+	// unlike form encoded and text/plain bodies
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		return c.jsonError(http.StatusUnsupportedMediaType, errors.New("body must be application/json"))
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return c.jsonError(http.StatusBadRequest, err)
+	}
+
+	u, err := NewUser(body.Username, body.Password, c)
+	if err == ErrUserExists {
+		return c.jsonError(http.StatusConflict, err)
+	} else if _, ok := err.(*PasswordError); ok {
+		return c.jsonError(http.StatusUnprocessableEntity, err)
+	} else if err != nil {
+		return err
+	}
+
+	u.SetEmail(body.Email)
+	for _, role := range body.Roles {
+		u.AddRole(role)
+	}
+
+	if !c.User.outranks(u) {
+		return c.jsonError(http.StatusForbidden, ErrOutranked)
+	}
+
+	if err = u.insert(c); err == ErrUserExists || err == ErrEmailExists {
+		return c.jsonError(http.StatusConflict, err)
+	} else if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, u)
+}
+
+// adminUser loads the user named in the URL for the admin handlers that
+// operate on a single user, including deleted users. Admins can only manage
+// users whose roles and permissions they all hold, otherwise a reset token
+// would hand them the account's privileges.
+func adminUser(h func(http.ResponseWriter, *http.Request, *Context, *User) error) Handler {
+	return func(w http.ResponseWriter, r *http.Request, c *Context) error {
+		u, err := FindUserIncludingDeleted(mux.Vars(r)["username"], c)
+		if err == mgo.ErrNotFound {
+			return c.jsonError(http.StatusNotFound, err)
+		} else if err != nil {
+			return err
+		}
+
+		if !c.User.outranks(u) {
+			return c.jsonError(http.StatusForbidden, ErrOutranked)
+		}
+
+		return h(w, r, c, u)
+	}
+}
+
+func adminGetUser(w http.ResponseWriter, r *http.Request, c *Context, u *User) error {
+	return c.JSON(http.StatusOK, u)
+}
+
+func adminDeleteUser(w http.ResponseWriter, r *http.Request, c *Context, u *User) (err error) {
+	if r.FormValue("purge") == "true" {
+		err = u.Purge(c)
+	} else {
+		err = u.Delete(c)
+	}
+
+	if err != nil {
+		return
+	}
+
+	return c.NoContent()
+}
+
+func adminDisableUser(w http.ResponseWriter, r *http.Request, c *Context, u *User) error {
+	if err := u.Disable(c); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, u)
+}
+
+func adminEnableUser(w http.ResponseWriter, r *http.Request, c *Context, u *User) error {
+	if err := u.Enable(c); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, u)
+}
+
+func adminRestoreUser(w http.ResponseWriter, r *http.Request, c *Context, u *User) error {
+	if err := u.Restore(c); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, u)
+}
+
+func adminResetToken(w http.ResponseWriter, r *http.Request, c *Context, u *User) error {
+	// RequestResetToken sets the context's user, which is the admin
+	admin := c.User
+	token, err := RequestResetToken(u.Username, c)
+	c.User = admin
+
+	if err == ErrAccountDisabled {
+		return c.jsonError(http.StatusConflict, err)
+	} else if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, map[string]string{
+		"username": token.Username,
+		"token":    token.Token,
+	})
+}
+
+func (c *Context) jsonError(status int, err error) error {
+	return c.JSON(status, map[string]string{"error": err.Error()})
+}
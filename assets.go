@@ -0,0 +1,118 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// staticMount records a directory of static files served by the app.
+type staticMount struct {
+	remote string
+	fsys   fs.FS
+}
+
+// assetRegistry holds the app's static files and caches their fingerprints.
+type assetRegistry struct {
+	mu     sync.Mutex
+	mounts []staticMount
+	hashes map[string]string
+}
+
+func (a *assetRegistry) add(remote string, fsys fs.FS) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.mounts = append(a.mounts, staticMount{remote: remote, fsys: fsys})
+	a.hashes = nil
+}
+
+// mount finds the static files serving name, using the same rules as
+// http.ServeMux: the longest matching pattern wins, and only patterns ending
+// in a slash match the paths below them.
+func (a *assetRegistry) mount(name string) (m staticMount, ok bool) {
+	for _, candidate := range a.mounts {
+		r := candidate.remote
+		if r == name || (strings.HasSuffix(r, "/") && strings.HasPrefix(name, r)) {
+			if !ok || len(r) > len(m.remote) {
+				m, ok = candidate, true
+			}
+		}
+	}
+
+	return
+}
+
+// Asset returns the URL of a static file served by RegisterStaticFileHandler
+// or RegisterStaticFS, with a fingerprint of its contents appended so that it
+// can be cached indefinitely:
+//
+//	g.Asset("/static/app.css") // "/static/app.css?v=3f2a9c04d1b7"
+//
+// Fingerprints are cached, except in development where they're recomputed so
+// that edits show up straight away.
+func (g *Goat) Asset(name string) (string, error) {
+	name = path.Clean("/" + name)
+
+	g.assets.mu.Lock()
+	v, ok := g.assets.hashes[name]
+	m, found := g.assets.mount(name)
+	g.assets.mu.Unlock()
+
+	if ok && !g.Config.Development {
+		return name + "?v=" + v, nil
+	}
+
+	if !found {
+		return "", fmt.Errorf("goat: no static files are served at %s", name)
+	}
+
+	// Static files are served without stripping the pattern, so the file
+	// is found at the full path
+	data, err := fs.ReadFile(m.fsys, strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	v = hex.EncodeToString(sum[:])[:12]
+
+	g.assets.mu.Lock()
+	if g.assets.hashes == nil {
+		g.assets.hashes = make(map[string]string)
+	}
+	g.assets.hashes[name] = v
+	g.assets.mu.Unlock()
+
+	return name + "?v=" + v, nil
+}
@@ -0,0 +1,257 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit event types
+const (
+	AuditLogin          = "login"
+	AuditResetRequested = "reset_requested"
+	AuditPasswordReset  = "password_reset"
+
+	AuditImpersonateStart = "impersonate_start"
+	AuditImpersonateStop  = "impersonate_stop"
+
+	AuditRememberTheft = "remember_token_theft"
+)
+
+// Audit event outcomes
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+var ErrAuditUnavailable = errors.New("audit sink does not support queries")
+
+type AuditEvent struct {
+	Id        bson.ObjectId `json:"id" bson:"_id"`
+	Type      string        `json:"type" bson:"type"`
+	Username  string        `json:"username" bson:"username"`
+	IP        string        `json:"ip" bson:"ip"`
+	UserAgent string        `json:"user_agent" bson:"user_agent"`
+	Timestamp time.Time     `json:"timestamp" bson:"timestamp"`
+	Outcome   string        `json:"outcome" bson:"outcome"`
+	Detail    string        `json:"detail,omitempty" bson:"detail,omitempty"`
+}
+
+// AuditSink receives the security events of an app.
+type AuditSink interface {
+	Record(e *AuditEvent) error
+}
+
+// AuditQuerier is implemented by sinks that can look up past events.
+type AuditQuerier interface {
+	// Recent returns up to limit of the user's events, newest first. The
+	// username is normalized.
+	Recent(username string, limit int) ([]AuditEvent, error)
+}
+
+// SetAuditSink enables the audit trail for logins, failed authentication,
+// password reset requests, password resets and impersonation.
+func (g *Goat) SetAuditSink(s AuditSink) {
+	g.audit = s
+}
+
+// RecentSecurityEvents returns up to limit of the user's most recent audit
+// events, newest first. ErrAuditUnavailable is returned if the app's sink
+// can't be queried.
+func RecentSecurityEvents(username string, limit int, c *Context) ([]AuditEvent, error) {
+	if c.goat == nil {
+		return nil, ErrAuditUnavailable
+	}
+
+	q, ok := c.goat.audit.(AuditQuerier)
+	if !ok {
+		return nil, ErrAuditUnavailable
+	}
+
+	return q.Recent(NormalizeUsername(username), limit)
+}
+
+// MongoAuditSink writes events to a capped collection, in the same way the
+// logger package does.
+type MongoAuditSink struct {
+	database   *mgo.Database
+	collection string
+}
+
+// NewMongoAuditSink creates the capped collection, limited to size bytes, if
+// it doesn't exist yet.
+func NewMongoAuditSink(collection string, size int, db *mgo.Database) (*MongoAuditSink, error) {
+	names, err := db.CollectionNames()
+	if err != nil {
+		return nil, err
+	}
+
+	exists := false
+	for _, n := range names {
+		exists = exists || n == collection
+	}
+
+	if !exists {
+		err = db.C(collection).Create(&mgo.CollectionInfo{
+			Capped:   true,
+			MaxBytes: size,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = db.C(collection).EnsureIndexKey("username"); err != nil {
+		return nil, err
+	}
+
+	return &MongoAuditSink{
+		database:   db,
+		collection: collection,
+	}, nil
+}
+
+func (m *MongoAuditSink) Record(e *AuditEvent) error {
+	s := m.database.Session.Copy()
+	defer s.Close()
+
+	return m.database.With(s).C(m.collection).Insert(e)
+}
+
+func (m *MongoAuditSink) Recent(username string, limit int) (events []AuditEvent, err error) {
+	s := m.database.Session.Copy()
+	defer s.Close()
+
+	err = m.database.With(s).C(m.collection).Find(bson.M{
+		"username": username,
+	}).Sort("-$natural").Limit(limit).All(&events)
+
+	return
+}
+
+// FileAuditSink appends events to a file as JSON lines.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileAuditSink{
+		path: path,
+		file: f,
+	}, nil
+}
+
+func (f *FileAuditSink) Record(e *AuditEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err = f.file.Write(append(data, '\n'))
+	return err
+}
+
+// Recent scans the whole file, so it's best suited to small logs.
+func (f *FileAuditSink) Recent(username string, limit int) ([]AuditEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var events []AuditEvent
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var e AuditEvent
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Username != username {
+			continue
+		}
+
+		events = append(events, e)
+		if limit > 0 && len(events) > limit {
+			events = events[1:]
+		}
+	}
+
+	// Newest first
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+
+	return events, scanner.Err()
+}
+
+func (f *FileAuditSink) Close() error {
+	return f.file.Close()
+}
+
+// audit records an event for the current request, if the app has an audit
+// sink. Usernames are normalized so that events are found whatever case the
+// name was typed in. Failures to record are ignored so that they never block
+// a login.
+func (c *Context) audit(event, username, outcome, detail string) {
+	if c.goat == nil || c.goat.audit == nil {
+		return
+	}
+
+	e := &AuditEvent{
+		Id:        bson.NewObjectId(),
+		Type:      event,
+		Username:  NormalizeUsername(username),
+		Timestamp: time.Now(),
+		Outcome:   outcome,
+		Detail:    detail,
+	}
+
+	if c.request != nil {
+		e.IP = clientIP(c.request)
+		e.UserAgent = c.request.UserAgent()
+	}
+
+	c.goat.audit.Record(e)
+}
@@ -0,0 +1,432 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Used by Bind when Config.MaxBodySize isn't set
+const DefaultMaxBodySize = 10 << 20
+
+var (
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+	// Compiled regex validation rules
+	patterns   = make(map[string]*regexp.Regexp)
+	patternsMu sync.Mutex
+
+	objectIdType = reflect.TypeOf(bson.ObjectId(""))
+	timeType     = reflect.TypeOf(time.Time{})
+	fileType     = reflect.TypeOf(&multipart.FileHeader{})
+)
+
+// Bind decodes the request into dst, which must be a pointer to a struct.
+// Query parameters are always bound, followed by the body according to its
+// Content-Type: JSON, form-urlencoded or multipart. Query and form values are
+// matched to fields by their "form" tag, falling back to the "json" tag and
+// then the field name; multipart files can be bound to
+// *multipart.FileHeader fields.
+//
+// The struct is then validated, see Validate. Malformed requests return a 400
+// *HTTPError, bodies over Config.MaxBodySize a 413, and requests that fail
+// validation a 422 with a message for each field.
+func (c *Context) Bind(r *http.Request, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("goat: Bind requires a pointer to a struct")
+	}
+
+	limit := int64(DefaultMaxBodySize)
+	if c.goat != nil && c.goat.Config.MaxBodySize > 0 {
+		limit = c.goat.Config.MaxBodySize
+	}
+
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(c.response, r.Body, limit)
+	}
+
+	if err := bindValues(v.Elem(), r.URL.Query(), nil); err != nil {
+		return err
+	}
+
+	if err := bindBody(r, v, limit); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return NewHTTPError(http.StatusRequestEntityTooLarge, "")
+		} else if _, ok := err.(*HTTPError); ok {
+			return err
+		}
+
+		return NewHTTPError(http.StatusBadRequest, "invalid request body: "+err.Error())
+	}
+
+	return Validate(dst)
+}
+
+func bindBody(r *http.Request, v reflect.Value, limit int64) error {
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil
+	}
+
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return json.NewDecoder(r.Body).Decode(v.Interface())
+	case mediaType == "application/x-www-form-urlencoded":
+		if err = r.ParseForm(); err != nil {
+			return err
+		}
+
+		return bindValues(v.Elem(), r.PostForm, nil)
+	case mediaType == "multipart/form-data":
+		if err = r.ParseMultipartForm(limit); err != nil {
+			return err
+		}
+
+		return bindValues(v.Elem(), r.MultipartForm.Value, r.MultipartForm.File)
+	}
+
+	return NewHTTPError(http.StatusUnsupportedMediaType, "")
+}
+
+// bindValues sets the fields of a struct from form values, collecting the
+// values that can't be converted into a 400.
+func bindValues(v reflect.Value, values url.Values, files map[string][]*multipart.FileHeader) error {
+	fields := make(map[string]string)
+
+	eachField(v, func(name string, f reflect.Value, sf reflect.StructField) {
+		if !f.CanSet() {
+			return
+		}
+
+		if fh := files[name]; len(fh) > 0 && f.Type() == fileType {
+			f.Set(reflect.ValueOf(fh[0]))
+			return
+		}
+
+		vals, ok := values[name]
+		if !ok {
+			return
+		}
+
+		if err := setField(f, vals); err != nil {
+			fields[name] = err.Error()
+		}
+	})
+
+	if len(fields) > 0 {
+		return &HTTPError{
+			Status:  http.StatusBadRequest,
+			Message: "invalid request parameters",
+			Fields:  fields,
+		}
+	}
+
+	return nil
+}
+
+// eachField calls fn for every exported field of a struct, descending into
+// embedded structs.
+func eachField(v reflect.Value, fn func(string, reflect.Value, reflect.StructField)) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		f := v.Field(i)
+
+		if sf.Anonymous && f.Kind() == reflect.Struct {
+			eachField(f, fn)
+			continue
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		if name := fieldName(sf); name != "-" {
+			fn(name, f, sf)
+		}
+	}
+}
+
+func fieldName(sf reflect.StructField) string {
+	for _, tag := range []string{"form", "json"} {
+		if name := strings.Split(sf.Tag.Get(tag), ",")[0]; name != "" {
+			return name
+		}
+	}
+
+	return sf.Name
+}
+
+func setField(f reflect.Value, vals []string) error {
+	switch f.Type() {
+	case objectIdType:
+		if !bson.IsObjectIdHex(vals[0]) {
+			return errors.New("must be a valid id")
+		}
+
+		f.Set(reflect.ValueOf(bson.ObjectIdHex(vals[0])))
+		return nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, vals[0])
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", vals[0]); err != nil {
+				return errors.New("must be a valid date")
+			}
+		}
+
+		f.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.Ptr:
+		p := reflect.New(f.Type().Elem())
+		if err := setField(p.Elem(), vals); err != nil {
+			return err
+		}
+
+		f.Set(p)
+	case reflect.Slice:
+		s := reflect.MakeSlice(f.Type(), len(vals), len(vals))
+		for i, val := range vals {
+			if err := setField(s.Index(i), []string{val}); err != nil {
+				return err
+			}
+		}
+
+		f.Set(s)
+	case reflect.String:
+		f.SetString(vals[0])
+	case reflect.Bool:
+		b, err := strconv.ParseBool(vals[0])
+		if vals[0] == "on" {
+			b, err = true, nil
+		}
+
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(vals[0], 10, f.Type().Bits())
+		if err != nil {
+			return errors.New("must be a whole number")
+		}
+
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(vals[0], 10, f.Type().Bits())
+		if err != nil {
+			return errors.New("must be a positive whole number")
+		}
+
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(vals[0], f.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+
+		f.SetFloat(n)
+	default:
+		return fmt.Errorf("can't be bound to %s", f.Type())
+	}
+
+	return nil
+}
+
+// Validate checks a struct against the rules in its "validate" tags,
+// returning a 422 *HTTPError with a message for every invalid field:
+//
+//	Name  string `validate:"required,max=40"`
+//	Email string `validate:"required,email"`
+//	Age   int    `validate:"min=13"`
+//	Slug  string `validate:"regex=^[a-z0-9-]+$"`
+//
+// min and max apply to the length of strings, slices and maps and to the
+// value of numbers. Since a regex may contain commas it must be the last
+// rule. Rules other than required are skipped for empty fields.
+func Validate(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := make(map[string]string)
+
+	eachField(rv, func(name string, f reflect.Value, sf reflect.StructField) {
+		if msg := validateField(f, sf.Tag.Get("validate")); msg != "" {
+			fields[name] = msg
+		}
+	})
+
+	if len(fields) > 0 {
+		return &HTTPError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "validation failed",
+			Fields:  fields,
+		}
+	}
+
+	return nil
+}
+
+func validateField(f reflect.Value, tag string) string {
+	if tag == "" {
+		return ""
+	}
+
+	var rules []string
+	if i := strings.Index(tag, "regex="); i >= 0 {
+		rules = append(strings.Split(strings.TrimSuffix(tag[:i], ","), ","), tag[i:])
+	} else {
+		rules = strings.Split(tag, ",")
+	}
+
+	empty := f.IsZero()
+
+	for _, rule := range rules {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+
+		if name == "required" {
+			if empty {
+				return "is required"
+			}
+
+			continue
+		}
+
+		if empty {
+			continue
+		}
+
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic("goat: invalid validate rule " + rule)
+			}
+
+			n, unit := measure(f)
+			if name == "min" && n < limit {
+				return fmt.Sprintf("must be at least %s%s", arg, unit)
+			} else if name == "max" && n > limit {
+				return fmt.Sprintf("must be at most %s%s", arg, unit)
+			}
+		case "email":
+			if s, ok := stringValue(f); !ok || !emailPattern.MatchString(s) {
+				return "must be a valid email address"
+			}
+		case "regex":
+			if s, ok := stringValue(f); !ok || !pattern(arg).MatchString(s) {
+				return "is invalid"
+			}
+		case "":
+		default:
+			panic("goat: unknown validate rule " + rule)
+		}
+	}
+
+	return ""
+}
+
+// measure returns the size of a value for min and max rules, along with the
+// unit to describe it by.
+func measure(f reflect.Value) (float64, string) {
+	switch f.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(f.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(f.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(f.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(f.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return f.Float(), ""
+	case reflect.Ptr:
+		return measure(f.Elem())
+	}
+
+	return 0, ""
+}
+
+// stringValue returns the string held by a string or string pointer field.
+func stringValue(f reflect.Value) (string, bool) {
+	if f.Kind() == reflect.Ptr {
+		return stringValue(f.Elem())
+	}
+
+	if f.Kind() != reflect.String {
+		return "", false
+	}
+
+	return f.String(), true
+}
+
+func pattern(expr string) *regexp.Regexp {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+
+	re, ok := patterns[expr]
+	if !ok {
+		re = regexp.MustCompile(expr)
+		patterns[expr] = re
+	}
+
+	return re
+}
@@ -0,0 +1,106 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"errors"
+	"testing"
+)
+
+type signup struct {
+	Name  string `validate:"required,max=10"`
+	Email string `validate:"required,email"`
+	Age   int    `validate:"min=13"`
+	Slug  string `json:"slug" validate:"regex=^[a-z0-9-]+$"`
+}
+
+func validationFields(t *testing.T, err error) map[string]string {
+	if err == nil {
+		return nil
+	}
+
+	var he *HTTPError
+	if !errors.As(err, &he) || he.Status != 422 {
+		t.Fatalf("Validate returned %v, want a 422 *HTTPError", err)
+	}
+
+	return he.Fields
+}
+
+func TestValidate(t *testing.T) {
+	valid := signup{Name: "bob", Email: "bob@example.com", Age: 30, Slug: "bob-1"}
+	if err := Validate(&valid); err != nil {
+		t.Fatalf("Validate(valid) = %v", err)
+	}
+
+	fields := validationFields(t, Validate(&signup{Name: "robert the bruce", Age: 3, Slug: "Bob!"}))
+	want := map[string]string{
+		"Name":  "must be at most 10 characters",
+		"Email": "is required",
+		"Age":   "must be at least 13",
+		"slug":  "is invalid",
+	}
+
+	if len(fields) != len(want) {
+		t.Fatalf("Validate returned %v, want %v", fields, want)
+	}
+
+	for name, msg := range want {
+		if fields[name] != msg {
+			t.Errorf("%s: got %q, want %q", name, fields[name], msg)
+		}
+	}
+}
+
+func TestValidateStructValue(t *testing.T) {
+	fields := validationFields(t, Validate(signup{Email: "bob@example.com"}))
+	if fields["Name"] != "is required" {
+		t.Fatalf("Validate of a struct value returned %v, want Name to be required", fields)
+	}
+}
+
+func TestValidatePointerFields(t *testing.T) {
+	type profile struct {
+		Email *string `validate:"email"`
+		Slug  *string `validate:"regex=^[a-z]+$"`
+	}
+
+	email, slug := "bob@example.com", "bob"
+	if err := Validate(&profile{Email: &email, Slug: &slug}); err != nil {
+		t.Fatalf("Validate(valid pointers) = %v", err)
+	}
+
+	email, slug = "bob", "Bob"
+	fields := validationFields(t, Validate(&profile{Email: &email, Slug: &slug}))
+	if fields["Email"] != "must be a valid email address" || fields["Slug"] != "is invalid" {
+		t.Fatalf("Validate(invalid pointers) returned %v", fields)
+	}
+
+	if err := Validate(&profile{}); err != nil {
+		t.Fatalf("Validate(nil pointers) = %v", err)
+	}
+}
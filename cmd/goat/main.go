@@ -0,0 +1,245 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+// The goat command manages the users of a goat app directly in its database.
+//
+//	goat -db mongodb://localhost/myapp create alice admin < password.txt
+//	goat -db mongodb://localhost/myapp reset-token alice
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/scottferg/goat"
+	"io"
+	"labix.org/v2/mgo"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	host    = flag.String("db", "localhost", "MongoDB host or URL of the app's database")
+	name    = flag.String("name", "", "database name, taken from the -db URL if empty")
+	deleted = flag.Bool("deleted", false, "include deleted users in list and search")
+)
+
+const usage = `usage: goat [flags] <command> [arguments]
+
+commands:
+  create <username> [role...]              create a user with the given roles, reading
+                                           the password from the first line of stdin
+  list                                     list all users
+  search <term>                            list users whose username or email contains term
+  disable <username>                       stop a user from logging in
+  enable <username>                        allow a disabled user to log in again
+  delete <username>                        soft-delete a user
+  restore <username>                       undo the deletion of a user
+  purge <username>                         permanently remove a user, deleted or not
+  purge-deleted <retention>                remove users deleted longer ago than retention, e.g. 720h
+  reset-token <username>                   issue a password reset token
+
+flags:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s, err := mgo.Dial(*host)
+	if err != nil {
+		fatal(err)
+	}
+	defer s.Close()
+
+	db := s.DB(databaseName(*host, *name))
+	if err = goat.EnsureUserIndexes(db); errors.As(err, new(*goat.UsernameCollisionError)) {
+		fmt.Fprintln(os.Stderr, "goat: warning:", err)
+	} else if err != nil {
+		fatal(err)
+	}
+
+	c := &goat.Context{Database: db}
+
+	if err = run(args[0], args[1:], c); err != nil {
+		fatal(err)
+	}
+}
+
+func run(cmd string, args []string, c *goat.Context) error {
+	switch cmd {
+	case "create":
+		if len(args) < 1 {
+			break
+		}
+
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+
+		_, err = goat.CreateUser(args[0], password, c, args[1:]...)
+
+		return err
+	case "list":
+		return list(goat.UserQuery{IncludeDeleted: *deleted}, c)
+	case "search":
+		if len(args) != 1 {
+			break
+		}
+
+		return list(goat.UserQuery{Search: args[0], IncludeDeleted: *deleted}, c)
+	case "disable", "enable", "delete", "restore", "purge":
+		if len(args) != 1 {
+			break
+		}
+
+		u, err := goat.FindUserIncludingDeleted(args[0], c)
+		if err != nil {
+			return err
+		}
+
+		switch cmd {
+		case "disable":
+			return u.Disable(c)
+		case "enable":
+			return u.Enable(c)
+		case "delete":
+			return u.Delete(c)
+		case "restore":
+			return u.Restore(c)
+		default:
+			return u.Purge(c)
+		}
+	case "purge-deleted":
+		if len(args) != 1 {
+			break
+		}
+
+		retention, err := time.ParseDuration(args[0])
+		if err != nil {
+			return err
+		}
+
+		n, err := goat.PurgeDeletedUsers(retention, c)
+		fmt.Printf("purged %d users\n", n)
+
+		return err
+	case "reset-token":
+		if len(args) != 1 {
+			break
+		}
+
+		token, err := goat.RequestResetToken(args[0], c)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(token.Token)
+
+		return nil
+	}
+
+	flag.Usage()
+	os.Exit(2)
+
+	return nil
+}
+
+// readPassword reads a password from the first line of stdin, so that it
+// doesn't end up in the shell history or the process list.
+func readPassword() (string, error) {
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("no password given on stdin")
+	}
+
+	return password, nil
+}
+
+func list(q goat.UserQuery, c *goat.Context) error {
+	users, err := goat.ListUsers(q, c)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tEMAIL\tROLES\tSTATUS")
+
+	for _, u := range users {
+		status := "active"
+		if u.DeletedAt != nil {
+			status = "deleted"
+		} else if u.Disabled {
+			status = "disabled"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.Username, u.Email, strings.Join(u.Roles, ","), status)
+	}
+
+	return w.Flush()
+}
+
+// databaseName works out the database the same way NewDatabaseMiddleware
+// does when it isn't given a name.
+func databaseName(host, name string) string {
+	if name != "" {
+		return name
+	}
+
+	if parsed, err := url.Parse(host); err == nil && len(parsed.Path) > 1 {
+		return parsed.Path[1:]
+	}
+
+	return host
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "goat:", err)
+	os.Exit(1)
+}
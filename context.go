@@ -28,15 +28,80 @@
 package goat
 
 import (
+	"context"
 	"github.com/gorilla/sessions"
 	"labix.org/v2/mgo"
 	"net/http"
+	"reflect"
+	"sync"
+	"time"
 )
 
 type Context struct {
 	Database *mgo.Database
 	Session  *sessions.Session
 	User     *User
+	// The user that is impersonating User, if any
+	Impersonator *User
+
+	goat     *Goat
+	request  *http.Request
+	response http.ResponseWriter
+	ctx      context.Context
+
+	mu     sync.Mutex
+	values map[string]interface{}
+}
+
+// Key identifies a typed value stored on a Context, so that middleware and
+// interceptors can pass data along to handlers:
+//
+//	var RequestID = goat.NewKey[string]("request_id")
+//
+//	RequestID.Set(c, id)
+//	id, ok := RequestID.Get(c)
+//
+// Templates that are handed the Context can read the value by name, e.g.
+// {{$.Context.Value "request_id"}}.
+type Key[T any] struct {
+	name string
+}
+
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+func (k Key[T]) Set(c *Context, v T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+
+	c.values[k.name] = v
+}
+
+// Get returns the value stored for the key. It reports false if nothing was
+// stored, or if it was stored by a key of another type with the same name.
+func (k Key[T]) Get(c *Context) (v T, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok = c.values[k.name].(T)
+
+	return
+}
+
+func (k Key[T]) Delete(c *Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.values, k.name)
 }
 
 func (c *Context) Close() {
@@ -45,11 +110,90 @@ func (c *Context) Close() {
 	}
 }
 
+// Context returns the context.Context of the request, which is cancelled when
+// the client goes away or the route's timeout passes.
+func (c *Context) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// Value returns the value stored on the context under name, or nil. It's
+// meant for templates; Go code should use a Key.
+func (c *Context) Value(name string) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[name]
+}
+
+// limitDatabase makes the operations of a database session give up once the
+// request's deadline has passed.
+func (c *Context) limitDatabase(s *mgo.Session) {
+	if deadline, ok := c.Context().Deadline(); ok {
+		s.SetSocketTimeout(time.Until(deadline))
+	}
+}
+
+// ClearSession logs the user out, including forgetting them on this device
+// if they asked to be remembered.
 func (c *Context) ClearSession(w http.ResponseWriter, r *http.Request) {
 	c.Session.Values["uid"] = nil
+	delete(c.Session.Values, "impersonator")
 	c.Session.Save(r, w)
+
+	c.forgetRemembered(w, r)
 }
 
 func NewContext() (*Context, error) {
 	return new(Context), nil
 }
+
+// lockout returns the login lockout configured on the app serving this
+// request, if any.
+func (c *Context) lockout() *Lockout {
+	if c.goat == nil {
+		return nil
+	}
+
+	return c.goat.lockout
+}
+
+// passwordPolicy returns the policy configured on the app serving this
+// request, or DefaultPasswordPolicy.
+func (c *Context) passwordPolicy() *PasswordPolicy {
+	if c.goat == nil || c.goat.passwordPolicy == nil {
+		return DefaultPasswordPolicy
+	}
+
+	return c.goat.passwordPolicy
+}
+
+// passwordHasher returns the hasher configured on the app serving this
+// request, or bcrypt at the password policy's cost.
+func (c *Context) passwordHasher() PasswordHasher {
+	if c.goat == nil || c.goat.passwordHasher == nil {
+		return BcryptHasher{Cost: c.passwordPolicy().cost()}
+	}
+
+	return c.goat.passwordHasher
+}
+
+// decodeProfile replaces the loosely decoded profile of a user with the
+// profile type registered on the app serving this request, if any.
+func (c *Context) decodeProfile(u *User) error {
+	if c.goat == nil || c.goat.profile == nil {
+		return nil
+	}
+
+	p := reflect.New(c.goat.profile).Interface()
+	if err := u.DecodeProfile(p); err != nil {
+		return err
+	}
+
+	u.Profile = p
+
+	return nil
+}
@@ -0,0 +1,102 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+)
+
+const (
+	// The form field and header a CSRF token is read from
+	CSRFField  = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+var ErrNoSession = errors.New("goat: CSRF tokens require the session middleware")
+
+// CSRFToken returns the token that forms and requests have to send back to
+// get past NewCSRFInterceptor, generating one and saving it in the session
+// the first time it's asked for. Templates rendered with Context.Render can
+// use {{csrf}} or {{csrfField}} instead.
+func (c *Context) CSRFToken() (string, error) {
+	if c.Session == nil {
+		return "", ErrNoSession
+	}
+
+	if token, ok := c.Session.Values["csrf"].(string); ok && token != "" {
+		return token, nil
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	c.Session.Values["csrf"] = token
+	if err := c.Session.Save(c.request, c.response); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// NewCSRFInterceptor protects routes from cross-site request forgery. Requests
+// using methods other than GET, HEAD, OPTIONS and TRACE must send the
+// session's CSRF token in the csrf_token form field or the X-CSRF-Token
+// header, otherwise forbidden is executed. If forbidden is nil, Generic403 is
+// used.
+func NewCSRFInterceptor(normal, forbidden Handler) Interceptor {
+	if forbidden == nil {
+		forbidden = Generic403
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, c *Context) Handler {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			return normal
+		}
+
+		if c.Session == nil {
+			return forbidden
+		}
+
+		expected, _ := c.Session.Values["csrf"].(string)
+
+		sent := r.Header.Get(CSRFHeader)
+		if sent == "" {
+			sent = r.FormValue(CSRFField)
+		}
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+			return forbidden
+		}
+
+		return normal
+	}
+}
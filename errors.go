@@ -0,0 +1,162 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// HTTPError can be returned from a Handler to respond with a specific status.
+// Errors with Fields, such as those returned by Bind, are rendered as JSON:
+//
+//	{"error": "validation failed", "fields": {"email": "is required"}}
+type HTTPError struct {
+	Status  int               `json:"-"`
+	Message string            `json:"error"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func NewHTTPError(status int, message string) *HTTPError {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	return &HTTPError{
+		Status:  status,
+		Message: message,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+// handleError renders the error returned by a route. Errors caused by the
+// request's context ending become a 504 when its deadline passed, or a 503
+// when it was cancelled.
+func (r route) handleError(w http.ResponseWriter, c *Context, err error) {
+	if err == nil {
+		return
+	}
+
+	if ctxErr := c.Context().Err(); ctxErr != nil {
+		err = ctxErr
+	}
+
+	var he *HTTPError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		he = NewHTTPError(http.StatusGatewayTimeout, "")
+	case errors.Is(err, context.Canceled):
+		he = NewHTTPError(http.StatusServiceUnavailable, "")
+	case errors.As(err, &he):
+	default:
+		he = NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if len(he.Fields) > 0 {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(he.Status)
+		json.NewEncoder(w).Encode(he)
+		return
+	}
+
+	http.Error(w, he.Message, he.Status)
+}
+
+// timeoutWriter buffers the response of a route with a timeout, discarding
+// anything written once it has expired.
+type timeoutWriter struct {
+	mu      sync.Mutex
+	header  http.Header
+	buf     bytes.Buffer
+	status  int
+	expired bool
+}
+
+func newTimeoutWriter() *timeoutWriter {
+	return &timeoutWriter{
+		header: make(http.Header),
+	}
+}
+
+func (t *timeoutWriter) Header() http.Header {
+	return t.header
+}
+
+func (t *timeoutWriter) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.expired {
+		return 0, context.DeadlineExceeded
+	}
+
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+
+	return t.buf.Write(b)
+}
+
+func (t *timeoutWriter) WriteHeader(status int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.expired && t.status == 0 {
+		t.status = status
+	}
+}
+
+func (t *timeoutWriter) expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.expired = true
+}
+
+func (t *timeoutWriter) flush(w http.ResponseWriter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for k, v := range t.header {
+		w.Header()[k] = v
+	}
+
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+
+	w.WriteHeader(t.status)
+	w.Write(t.buf.Bytes())
+}
@@ -28,15 +28,25 @@
 package goat
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+	"io/fs"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -53,28 +63,75 @@ const (
 
 type Config struct {
 	Spdy bool
+	// Enables conveniences for development, such as re-parsing templates
+	// loaded with LoadTemplates when they change
+	Development bool
+	// How long remember-me cookies last, DefaultRememberDuration if zero
+	RememberDuration time.Duration
+	// The largest request body Context.Bind accepts, DefaultMaxBodySize
+	// if zero
+	MaxBodySize int64
+	// The scheme and host the app is reached at, used by Goat.Absolute,
+	// e.g. "https://example.com"
+	BaseURL string
 }
 
 type Goat struct {
-	Router       *mux.Router
-	Config       Config
-	routes       map[string]*route
-	middleware   []Middleware
-	dbsession    *mgo.Session
-	dbname       string
-	sessionstore sessions.Store
-	listener     *net.TCPListener
-	servemux     *http.ServeMux
+	Router         *mux.Router
+	Config         Config
+	routes         map[string]*route
+	middleware     []Middleware
+	dbsession      *mgo.Session
+	dbname         string
+	sessionstore   sessions.Store
+	listener       *net.TCPListener
+	servemux       *http.ServeMux
+	lockout        *Lockout
+	passwordPolicy *PasswordPolicy
+	passwordHasher PasswordHasher
+	profile        reflect.Type
+	audit          AuditSink
+	templates      *templateSet
+	funcs          funcRegistry
+	assets         assetRegistry
+	renderers      []Renderer
 }
 
 type Handler func(http.ResponseWriter, *http.Request, *Context) error
 
 type route struct {
 	*Goat
-	path        string
-	name        string
-	handler     Handler
-	interceptor Interceptor
+	path         string
+	name         string
+	methods      []string
+	handler      Handler
+	interceptor  Interceptor
+	interceptors []Interceptor
+	timeout      time.Duration
+}
+
+// RouteOption configures a route when it's registered.
+type RouteOption func(*route)
+
+// Timeout sets a deadline for each request to a route. The deadline is
+// carried by the Context and the request's context.Context, and limits
+// database operations made through the database middleware. If it passes
+// before the handler returns, a 504 is rendered and anything the handler
+// writes afterwards is discarded. Responses from these routes are buffered.
+func Timeout(d time.Duration) RouteOption {
+	return func(r *route) {
+		r.timeout = d
+	}
+}
+
+// RouteInfo describes a route registered with RegisterRoute.
+type RouteInfo struct {
+	Name    string
+	Path    string
+	Methods []string
+	// Names of the interceptors guarding the route, in the order in which
+	// they run
+	Interceptors []string
 }
 
 func (r route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -84,7 +141,78 @@ func (r route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if c, err = NewContext(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
-	defer c.Close()
+
+	ctx := req.Context()
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+
+		req = req.WithContext(ctx)
+	}
+
+	c.goat = r.Goat
+	c.request = req
+	c.ctx = ctx
+
+	if r.timeout == 0 {
+		defer c.Close()
+
+		r.handleError(w, c, r.serve(w, req, c))
+		return
+	}
+
+	// Run the route in the background so that we can give up on it when
+	// the deadline passes. Its response is buffered so that a late
+	// handler can't write over the timeout error.
+	tw := newTimeoutWriter()
+	done := make(chan error, 1)
+	panicked := make(chan interface{}, 1)
+
+	go func() {
+		// net/http only recovers panics on the goroutine serving the
+		// request, so they're handed back to it
+		defer func() {
+			if p := recover(); p != nil {
+				panicked <- p
+			}
+		}()
+
+		done <- r.serve(tw, req, c)
+	}()
+
+	select {
+	case err = <-done:
+		c.Close()
+
+		r.handleError(tw, c, err)
+		tw.flush(w)
+	case p := <-panicked:
+		c.Close()
+
+		panic(p)
+	case <-ctx.Done():
+		tw.expire()
+
+		// The handler may still be using the database. A panic after
+		// the timeout has been sent is dropped, as with
+		// http.TimeoutHandler.
+		go func() {
+			select {
+			case <-done:
+			case <-panicked:
+			}
+
+			c.Close()
+		}()
+
+		r.handleError(w, c, ctx.Err())
+	}
+}
+
+// serve runs the middleware, interceptors and handler of the route.
+func (r route) serve(w http.ResponseWriter, req *http.Request, c *Context) error {
+	c.response = w
 
 	// Execute Middleware
 	for _, m := range r.middleware {
@@ -93,15 +221,18 @@ func (r route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	// Execute the handler
 	if r.handler != nil {
-		err = r.handler(w, req, c)
+		return r.handler(w, req, c)
 	} else if r.interceptor != nil {
 		rh := r.interceptor(w, req, c)
-		err = rh(w, req, c)
+		if rh == nil {
+			// Every interceptor passed the request through without
+			// providing a Handler, so there's nothing to serve
+			rh = Generic403
+		}
+		return rh(w, req, c)
 	}
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	return nil
 }
 
 func methodList(methods int) (r []string) {
@@ -147,13 +278,17 @@ func New(c *Config) *Goat {
 	return result
 }
 
-func (g *Goat) RegisterRoute(path, name string, method int, handler interface{}) {
+func (g *Goat) RegisterRoute(path, name string, method int, handler interface{}, opts ...RouteOption) {
 	// Initialize the HTTP router
 	r := new(route)
 	r.Goat = g
 	r.path = path
 	r.name = name
 
+	for _, opt := range opts {
+		opt(r)
+	}
+
 	if g.routes[r.name] != nil {
 		return
 	}
@@ -166,6 +301,10 @@ func (g *Goat) RegisterRoute(path, name string, method int, handler interface{})
 		r.handler = h
 	} else if i, ok := handler.(Interceptor); ok {
 		r.interceptor = i
+		r.interceptors = []Interceptor{i}
+	} else if i, ok := handler.([]Interceptor); ok {
+		r.interceptor = ChainInterceptors(i...)
+		r.interceptors = i
 	} else if h, ok := handler.(http.Handler); ok {
 		g.Router.Handle(path, h)
 		return
@@ -173,8 +312,58 @@ func (g *Goat) RegisterRoute(path, name string, method int, handler interface{})
 		panic("Unknown handler passed to RegisterRoute")
 	}
 
-	methods := methodList(method)
-	g.Router.Handle(path, r).Methods(methods...).Name(r.name)
+	r.methods = methodList(method)
+	g.Router.Handle(path, r).Methods(r.methods...).Name(r.name)
+}
+
+// Routes returns a description of every route registered through
+// RegisterRoute, sorted by name.
+func (g *Goat) Routes() (r []RouteInfo) {
+	for _, rt := range g.routes {
+		info := RouteInfo{
+			Name:    rt.name,
+			Path:    rt.path,
+			Methods: rt.methods,
+		}
+
+		for _, i := range rt.interceptors {
+			info.Interceptors = append(info.Interceptors, funcName(i))
+		}
+
+		r = append(r, info)
+	}
+
+	sort.Sort(byName(r))
+
+	return
+}
+
+type byName []RouteInfo
+
+func (r byName) Len() int           { return len(r) }
+func (r byName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byName) Less(i, j int) bool { return r[i].Name < r[j].Name }
+
+// funcName returns the name of the function that built an interceptor,
+// e.g. "goat.NewAuthSessionInterceptor".
+func funcName(f interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	name := fn.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	// Interceptors are closures, so strip the ".func1" suffix to get
+	// back to the function that created them
+	if i := strings.Index(name, ".func"); i >= 0 {
+		name = name[:i]
+	}
+
+	return name
 }
 
 func (g *Goat) CopyDB() *mgo.Database {
@@ -186,16 +375,100 @@ func (g *Goat) CloneDB() *mgo.Database {
 }
 
 func (g *Goat) RegisterStaticFileHandler(remote, local string) {
+	if local == "" {
+		local = "."
+	}
+
 	// Static file handler
 	g.servemux.Handle(remote, http.FileServer(http.Dir(local)))
+	g.assets.add(remote, os.DirFS(local))
+}
+
+// RegisterStaticFS serves static files from fsys, such as an embed.FS, in
+// the same way as RegisterStaticFileHandler serves them from disk.
+func (g *Goat) RegisterStaticFS(remote string, fsys fs.FS) {
+	g.servemux.Handle(remote, http.FileServer(http.FS(fsys)))
+	g.assets.add(remote, fsys)
 }
 
 func (g *Goat) RegisterMiddleware(m Middleware) {
 	g.middleware = append(g.middleware, m)
 }
 
+// UnknownRouteError is returned when reversing a route name that hasn't
+// been registered.
+type UnknownRouteError struct {
+	Name string
+}
+
+func (e *UnknownRouteError) Error() string {
+	return fmt.Sprintf("goat: no route named %q", e.Name)
+}
+
+var ErrNoBaseURL = errors.New("goat: Config.BaseURL must be set to build absolute URLs")
+
+// Reverse builds the URL of a named route from pairs of route variables and
+// values:
+//
+//	u, err := g.Reverse("user", "id", id.Hex())
+//
+// An *UnknownRouteError is returned if there is no route with that name.
 func (g *Goat) Reverse(root string, params ...string) (*url.URL, error) {
-	return g.Router.Get(root).URL(params...)
+	r := g.Router.Get(root)
+	if r == nil {
+		return nil, &UnknownRouteError{Name: root}
+	}
+
+	return r.URL(params...)
+}
+
+// ReverseQuery is like Reverse, but adds query to the URL.
+func (g *Goat) ReverseQuery(root string, query url.Values, params ...string) (*url.URL, error) {
+	u, err := g.Reverse(root, params...)
+	if err != nil || len(query) == 0 {
+		return u, err
+	}
+
+	q := u.Query()
+	for k, v := range query {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	return u, nil
+}
+
+// Absolute makes a URL built by Reverse absolute using Config.BaseURL, for
+// links that leave the app, such as those in emails:
+//
+//	u, err := g.ReverseQuery("verify", url.Values{"token": {t.Token}})
+//	if err == nil {
+//		u, err = g.Absolute(u)
+//	}
+//
+// If BaseURL has a path, such as when the app is served from a
+// subdirectory, it's prepended to the URL's path.
+func (g *Goat) Absolute(u *url.URL) (*url.URL, error) {
+	if g.Config.BaseURL == "" {
+		return nil, ErrNoBaseURL
+	}
+
+	base, err := url.Parse(g.Config.BaseURL)
+	if err != nil {
+		return nil, err
+	} else if !base.IsAbs() || base.Host == "" {
+		return nil, fmt.Errorf("goat: Config.BaseURL is not absolute: %q", g.Config.BaseURL)
+	}
+
+	abs := *u
+	abs.Scheme = base.Scheme
+	abs.Host = base.Host
+	abs.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+	if u.RawPath != "" {
+		abs.RawPath = strings.TrimSuffix(base.EscapedPath(), "/") + u.RawPath
+	}
+
+	return &abs, nil
 }
 
 func (g *Goat) ListenAndServe(port string) error {
@@ -0,0 +1,125 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeoutRoutePanic(t *testing.T) {
+	g := New(nil)
+	g.RegisterRoute("/panic", "panic", GET, func(w http.ResponseWriter, r *http.Request, c *Context) error {
+		panic("boom")
+	}, Timeout(time.Second))
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Fatalf("recovered %v, want the handler's panic", p)
+		}
+	}()
+
+	g.Router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+}
+
+func TestReverse(t *testing.T) {
+	g := New(&Config{BaseURL: "https://example.com/"})
+	g.RegisterRoute("/users/{id}", "user", GET, func(w http.ResponseWriter, r *http.Request, c *Context) error {
+		return nil
+	})
+
+	var unknown *UnknownRouteError
+	if _, err := g.Reverse("missing"); !errors.As(err, &unknown) || unknown.Name != "missing" {
+		t.Fatalf("Reverse of an unknown route returned %v", err)
+	}
+
+	u, err := g.ReverseQuery("user", url.Values{"tab": {"posts"}}, "id", "7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.String() != "/users/7?tab=posts" {
+		t.Fatalf("ReverseQuery = %s", u)
+	}
+
+	if u, err = g.Absolute(u); err != nil || u.String() != "https://example.com/users/7?tab=posts" {
+		t.Fatalf("Absolute = %s, %v", u, err)
+	}
+
+	g.Config.BaseURL = ""
+	if _, err = g.Absolute(u); err != ErrNoBaseURL {
+		t.Fatalf("Absolute without a base URL returned %v", err)
+	}
+}
+
+func TestInterceptorsWithoutHandler(t *testing.T) {
+	pass := func(w http.ResponseWriter, r *http.Request, c *Context) Handler {
+		return nil
+	}
+
+	g := New(nil)
+	g.RegisterRoute("/", "index", GET, []Interceptor{pass, pass})
+
+	w := httptest.NewRecorder()
+	g.Router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got %d, want 403", w.Code)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	h := func(w http.ResponseWriter, r *http.Request, c *Context) error {
+		return nil
+	}
+
+	g := New(nil)
+	g.RegisterRoute("/posts", "posts", GET|POST, []Interceptor{
+		NewCSRFInterceptor(nil, nil),
+		NewRequireRoleInterceptor([]string{"editor"}, h, nil),
+	})
+	g.RegisterRoute("/", "index", GET, h)
+
+	want := []RouteInfo{
+		{Name: "index", Path: "/", Methods: []string{"GET"}},
+		{
+			Name:         "posts",
+			Path:         "/posts",
+			Methods:      []string{"GET", "POST"},
+			Interceptors: []string{"goat.NewCSRFInterceptor", "goat.NewRequireRoleInterceptor"},
+		},
+	}
+
+	if got := g.Routes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
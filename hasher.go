@@ -0,0 +1,362 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("password does not match")
+	ErrUnknownHash      = errors.New("unknown password hash format")
+
+	// Used to verify stored hashes. Comparisons only rely on the parameters
+	// encoded in the hash, so the zero values are enough.
+	hashers = map[string]PasswordHasher{
+		"bcrypt":   BcryptHasher{},
+		"scrypt":   ScryptHasher{},
+		"argon2id": Argon2idHasher{},
+	}
+)
+
+// PasswordHasher hashes passwords for storage in User.Password. Hashes are
+// stored as "<algorithm>$<encoded hash>" so that Authenticate can tell which
+// hasher produced them.
+type PasswordHasher interface {
+	// The algorithm name used to prefix stored hashes
+	Algorithm() string
+	// Hash returns the encoded hash, including the algorithm prefix
+	Hash(password []byte) ([]byte, error)
+	// Compare returns ErrPasswordMismatch if the password doesn't match
+	Compare(hash, password []byte) error
+	// NeedsRehash reports whether a hash from this algorithm was made with
+	// parameters other than the hasher's
+	NeedsRehash(hash []byte) bool
+}
+
+// SetPasswordHasher sets the hasher used for new passwords. When a user logs
+// in with a password hashed by another algorithm or with outdated parameters
+// it is transparently re-hashed with this one.
+func (g *Goat) SetPasswordHasher(h PasswordHasher) {
+	g.passwordHasher = h
+}
+
+// ComparePassword checks a plaintext password against a stored hash made by
+// any of goat's hashers, including unprefixed bcrypt hashes from older
+// versions of goat.
+func ComparePassword(hash []byte, password string) error {
+	alg, _ := splitHash(hash)
+
+	h, ok := hashers[alg]
+	if !ok {
+		return ErrUnknownHash
+	}
+
+	return h.Compare(hash, []byte(password))
+}
+
+// needsRehash reports whether a stored hash should be replaced by one from h.
+func needsRehash(h PasswordHasher, hash []byte) bool {
+	if alg, _ := splitHash(hash); alg != h.Algorithm() || !bytes.HasPrefix(hash, []byte(alg+"$")) {
+		return true
+	}
+
+	return h.NeedsRehash(hash)
+}
+
+// splitHash separates the algorithm prefix from a stored hash. Raw bcrypt
+// hashes, which is how goat used to store passwords, are reported as
+// "bcrypt".
+func splitHash(hash []byte) (string, []byte) {
+	if bytes.HasPrefix(hash, []byte("$2")) {
+		return "bcrypt", hash
+	}
+
+	if i := bytes.IndexByte(hash, '$'); i > 0 {
+		return string(hash[:i]), hash[i+1:]
+	}
+
+	return "", hash
+}
+
+type BcryptHasher struct {
+	// bcrypt.DefaultCost if zero
+	Cost int
+}
+
+func (b BcryptHasher) Algorithm() string {
+	return "bcrypt"
+}
+
+func (b BcryptHasher) Hash(password []byte) ([]byte, error) {
+	h, err := bcrypt.GenerateFromPassword(password, b.cost())
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte("bcrypt$"), h...), nil
+}
+
+func (b BcryptHasher) Compare(hash, password []byte) error {
+	_, h := splitHash(hash)
+
+	if err := bcrypt.CompareHashAndPassword(h, password); err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrPasswordMismatch
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (b BcryptHasher) NeedsRehash(hash []byte) bool {
+	_, h := splitHash(hash)
+
+	cost, err := bcrypt.Cost(h)
+	return err != nil || cost != b.cost()
+}
+
+func (b BcryptHasher) cost() int {
+	if b.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+
+	return b.Cost
+}
+
+// ScryptHasher stores hashes as "scrypt$N=<n>,r=<r>,p=<p>$<salt>$<key>".
+// Zero parameters fall back to N=32768, r=8, p=1 with a 32 byte key.
+type ScryptHasher struct {
+	N, R, P int
+	KeyLen  int
+}
+
+func (s ScryptHasher) Algorithm() string {
+	return "scrypt"
+}
+
+func (s ScryptHasher) Hash(password []byte) ([]byte, error) {
+	s = s.withDefaults()
+
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(password, salt, s.N, s.R, s.P, s.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(fmt.Sprintf("scrypt$N=%d,r=%d,p=%d$%s$%s",
+		s.N, s.R, s.P, encodeHashPart(salt), encodeHashPart(key))), nil
+}
+
+func (s ScryptHasher) Compare(hash, password []byte) error {
+	stored, salt, key, err := parseScrypt(hash)
+	if err != nil {
+		return err
+	}
+
+	k, err := scrypt.Key(password, salt, stored.N, stored.R, stored.P, len(key))
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(k, key) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (s ScryptHasher) NeedsRehash(hash []byte) bool {
+	stored, _, key, err := parseScrypt(hash)
+	if err != nil {
+		return true
+	}
+
+	s = s.withDefaults()
+	return stored.N != s.N || stored.R != s.R || stored.P != s.P || len(key) != s.KeyLen
+}
+
+func (s ScryptHasher) withDefaults() ScryptHasher {
+	if s.N == 0 {
+		s.N = 32768
+	}
+
+	if s.R == 0 {
+		s.R = 8
+	}
+
+	if s.P == 0 {
+		s.P = 1
+	}
+
+	if s.KeyLen == 0 {
+		s.KeyLen = 32
+	}
+
+	return s
+}
+
+func parseScrypt(hash []byte) (s ScryptHasher, salt, key []byte, err error) {
+	var encSalt, encKey string
+
+	_, h := splitHash(hash)
+	if _, err = fmt.Sscanf(string(bytes.Replace(h, []byte("$"), []byte(" "), -1)),
+		"N=%d,r=%d,p=%d %s %s", &s.N, &s.R, &s.P, &encSalt, &encKey); err != nil {
+		return s, nil, nil, ErrUnknownHash
+	}
+
+	if salt, err = decodeHashPart(encSalt); err != nil {
+		return
+	}
+
+	key, err = decodeHashPart(encKey)
+
+	return
+}
+
+// Argon2idHasher stores hashes as
+// "argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>". Zero
+// parameters fall back to t=1, m=64MB, p=4 with a 32 byte key.
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+}
+
+func (a Argon2idHasher) Algorithm() string {
+	return "argon2id"
+}
+
+func (a Argon2idHasher) Hash(password []byte) ([]byte, error) {
+	a = a.withDefaults()
+
+	salt, err := newSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey(password, salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+
+	return []byte(fmt.Sprintf("argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Time, a.Threads, encodeHashPart(salt), encodeHashPart(key))), nil
+}
+
+func (a Argon2idHasher) Compare(hash, password []byte) error {
+	stored, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	k := argon2.IDKey(password, salt, stored.Time, stored.Memory, stored.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(k, key) != 1 {
+		return ErrPasswordMismatch
+	}
+
+	return nil
+}
+
+func (a Argon2idHasher) NeedsRehash(hash []byte) bool {
+	stored, _, key, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	a = a.withDefaults()
+	return stored.Time != a.Time || stored.Memory != a.Memory ||
+		stored.Threads != a.Threads || uint32(len(key)) != a.KeyLen
+}
+
+func (a Argon2idHasher) withDefaults() Argon2idHasher {
+	if a.Time == 0 {
+		a.Time = 1
+	}
+
+	if a.Memory == 0 {
+		a.Memory = 64 * 1024
+	}
+
+	if a.Threads == 0 {
+		a.Threads = 4
+	}
+
+	if a.KeyLen == 0 {
+		a.KeyLen = 32
+	}
+
+	return a
+}
+
+func parseArgon2id(hash []byte) (a Argon2idHasher, salt, key []byte, err error) {
+	var version int
+	var encSalt, encKey string
+
+	_, h := splitHash(hash)
+	if _, err = fmt.Sscanf(string(bytes.Replace(h, []byte("$"), []byte(" "), -1)),
+		"v=%d m=%d,t=%d,p=%d %s %s", &version, &a.Memory, &a.Time, &a.Threads, &encSalt, &encKey); err != nil {
+		return a, nil, nil, ErrUnknownHash
+	}
+
+	if version != argon2.Version {
+		return a, nil, nil, ErrUnknownHash
+	}
+
+	if salt, err = decodeHashPart(encSalt); err != nil {
+		return
+	}
+
+	key, err = decodeHashPart(encKey)
+
+	return
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+
+	return salt, err
+}
+
+func encodeHashPart(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func decodeHashPart(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,119 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"bytes"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+)
+
+// Cheap parameters so the tests run quickly
+var testHashers = []PasswordHasher{
+	BcryptHasher{Cost: bcrypt.MinCost},
+	ScryptHasher{N: 1024, R: 8, P: 1},
+	Argon2idHasher{Time: 1, Memory: 1024, Threads: 1},
+}
+
+func TestHasherRoundTrip(t *testing.T) {
+	for _, h := range testHashers {
+		hash, err := h.Hash([]byte("correct horse"))
+		if err != nil {
+			t.Fatalf("%s: %v", h.Algorithm(), err)
+		}
+
+		if !bytes.HasPrefix(hash, []byte(h.Algorithm()+"$")) {
+			t.Errorf("%s: hash %q isn't prefixed with the algorithm", h.Algorithm(), hash)
+		}
+
+		if err = ComparePassword(hash, "correct horse"); err != nil {
+			t.Errorf("%s: ComparePassword with the right password = %v", h.Algorithm(), err)
+		}
+
+		if err = ComparePassword(hash, "battery staple"); err != ErrPasswordMismatch {
+			t.Errorf("%s: ComparePassword with the wrong password = %v", h.Algorithm(), err)
+		}
+
+		if needsRehash(h, hash) {
+			t.Errorf("%s: fresh hash needs rehashing", h.Algorithm())
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ComparePassword(legacy, "secret"); err != nil {
+		t.Fatalf("ComparePassword of an unprefixed bcrypt hash = %v", err)
+	}
+
+	if !needsRehash(BcryptHasher{Cost: bcrypt.MinCost}, legacy) {
+		t.Error("unprefixed bcrypt hash doesn't need rehashing")
+	}
+
+	old, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !needsRehash(BcryptHasher{Cost: bcrypt.MinCost + 1}, old) {
+		t.Error("bcrypt hash with a lower cost doesn't need rehashing")
+	}
+
+	if !needsRehash(ScryptHasher{N: 1024, R: 8, P: 1}, old) {
+		t.Error("bcrypt hash doesn't need rehashing with scrypt")
+	}
+
+	scrypted, err := ScryptHasher{N: 1024, R: 8, P: 1}.Hash([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !needsRehash(ScryptHasher{N: 2048, R: 8, P: 1}, scrypted) {
+		t.Error("scrypt hash with a lower N doesn't need rehashing")
+	}
+
+	argon, err := Argon2idHasher{Time: 1, Memory: 1024, Threads: 1}.Hash([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !needsRehash(Argon2idHasher{Time: 2, Memory: 1024, Threads: 1}, argon) {
+		t.Error("argon2id hash with a lower time doesn't need rehashing")
+	}
+}
+
+func TestComparePasswordUnknownHash(t *testing.T) {
+	for _, hash := range []string{"", "md5$abc", "scrypt$garbage", "argon2id$v=19$garbage"} {
+		if err := ComparePassword([]byte(hash), "secret"); err == nil || err == ErrPasswordMismatch {
+			t.Errorf("ComparePassword(%q) = %v, want a format error", hash, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"errors"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+)
+
+// Users need this permission to impersonate others.
+const ImpersonatePermission = "impersonate"
+
+var (
+	ErrCannotImpersonate = errors.New("not allowed to impersonate users")
+	ErrNotImpersonating  = errors.New("not impersonating a user")
+)
+
+// Impersonating reports whether the current user is being impersonated by
+// someone else, so that templates can make it obvious.
+func (c *Context) Impersonating() bool {
+	return c.Impersonator != nil
+}
+
+// Impersonate logs the current user in as target, while remembering who
+// they really are so that StopImpersonating can switch back. The current
+// user needs the ImpersonatePermission, and every role and permission that
+// target has, so that impersonating can't be used to gain privileges.
+// Users that can impersonate others can't be impersonated themselves.
+func (c *Context) Impersonate(w http.ResponseWriter, r *http.Request, target *User) (err error) {
+	if c.User == nil || c.Impersonating() || !canImpersonate(c.User, target) {
+		err = ErrCannotImpersonate
+	} else if !target.Active() {
+		err = ErrAccountDisabled
+	}
+
+	if err != nil {
+		if c.User != nil {
+			c.audit(AuditImpersonateStart, c.User.Username, AuditFailure, err.Error())
+		}
+
+		return
+	}
+
+	c.Session.Values["impersonator"] = c.User.Id
+	c.Session.Values["uid"] = target.Id
+	if err = c.Session.Save(r, w); err != nil {
+		return
+	}
+
+	c.Impersonator, c.User = c.User, target
+
+	// Record the event for both users so that it shows up in either
+	// history
+	c.audit(AuditImpersonateStart, c.Impersonator.Username, AuditSuccess, "impersonating "+target.Username)
+	c.audit(AuditImpersonateStart, target.Username, AuditSuccess, "impersonated by "+c.Impersonator.Username)
+
+	return
+}
+
+// StopImpersonating switches the session back to the user that started
+// impersonating.
+func (c *Context) StopImpersonating(w http.ResponseWriter, r *http.Request) error {
+	if !c.Impersonating() {
+		return ErrNotImpersonating
+	}
+
+	c.Session.Values["uid"] = c.Impersonator.Id
+	delete(c.Session.Values, "impersonator")
+	if err := c.Session.Save(r, w); err != nil {
+		return err
+	}
+
+	target := c.User
+	c.User, c.Impersonator = c.Impersonator, nil
+
+	c.audit(AuditImpersonateStop, c.User.Username, AuditSuccess, "stopped impersonating "+target.Username)
+	c.audit(AuditImpersonateStop, target.Username, AuditSuccess, "no longer impersonated by "+c.User.Username)
+
+	return nil
+}
+
+// loadImpersonator populates the impersonator of a session, checking that
+// they are still allowed to impersonate.
+func (c *Context) loadImpersonator() error {
+	id, ok := c.Session.Values["impersonator"].(bson.ObjectId)
+	if !ok {
+		return nil
+	}
+
+	var u *User
+	if err := c.Database.C("goat_users").FindId(id).One(&u); err != nil {
+		return err
+	}
+
+	if !u.Active() || c.User == nil || !canImpersonate(u, c.User) {
+		return ErrCannotImpersonate
+	}
+
+	c.Impersonator = u
+
+	return nil
+}
+
+// canImpersonate reports whether impersonator may act as target.
+func canImpersonate(impersonator, target *User) bool {
+	if !impersonator.HasPermission(ImpersonatePermission) || target.HasPermission(ImpersonatePermission) {
+		return false
+	}
+
+	return impersonator.outranks(target) && impersonator.Id != target.Id
+}
@@ -0,0 +1,61 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestCanImpersonate(t *testing.T) {
+	support := &User{
+		Id:          bson.NewObjectId(),
+		Roles:       []string{"support", "editor"},
+		Permissions: []string{ImpersonatePermission, "posts.edit", "posts.delete"},
+	}
+
+	tests := []struct {
+		name         string
+		impersonator *User
+		target       *User
+		want         bool
+	}{
+		{"lesser target", support, &User{Id: bson.NewObjectId(), Roles: []string{"editor"}, Permissions: []string{"posts.edit"}}, true},
+		{"target without privileges", support, &User{Id: bson.NewObjectId()}, true},
+		{"target has a role the impersonator lacks", support, &User{Id: bson.NewObjectId(), Roles: []string{"admin"}}, false},
+		{"target has a permission the impersonator lacks", support, &User{Id: bson.NewObjectId(), Permissions: []string{"users.delete"}}, false},
+		{"target can impersonate", support, &User{Id: bson.NewObjectId(), Permissions: []string{ImpersonatePermission}}, false},
+		{"impersonating yourself", support, support, false},
+		{"impersonator without the permission", &User{Id: bson.NewObjectId(), Roles: []string{"support"}}, &User{Id: bson.NewObjectId()}, false},
+	}
+
+	for _, test := range tests {
+		if got := canImpersonate(test.impersonator, test.target); got != test.want {
+			t.Errorf("%s: canImpersonate = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
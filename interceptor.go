@@ -28,15 +28,49 @@
 package goat
 
 import (
-	"bytes"
-	"encoding/base64"
 	"labix.org/v2/mgo/bson"
 	"net/http"
-	"strings"
 )
 
 type Interceptor func(http.ResponseWriter, *http.Request, *Context) Handler
 
+// Handler adapts the interceptor into a Handler, so that it can be passed
+// as the normal route of another interceptor:
+//
+//	NewAuthSessionInterceptor(NewRequireRoleInterceptor(roles, h, nil).Handler(), login)
+func (i Interceptor) Handler() Handler {
+	return func(w http.ResponseWriter, r *http.Request, c *Context) error {
+		h := i(w, r, c)
+		if h == nil {
+			h = Generic403
+		}
+
+		return h(w, r, c)
+	}
+}
+
+// ChainInterceptors combines several interceptors into one. The interceptors
+// are run in order and each may either pass the request through by returning
+// a nil Handler, or end the chain by returning the Handler to execute. This
+// means the interceptors at the front of a chain are usually built with a nil
+// normal Handler and the last one is given the route's Handler:
+//
+//	ChainInterceptors(
+//		NewAuthSessionInterceptor(nil, Login),
+//		NewRequireRoleInterceptor([]string{"admin"}, AdminIndex, nil),
+//	)
+func ChainInterceptors(interceptors ...Interceptor) Interceptor {
+	return func(w http.ResponseWriter, r *http.Request, c *Context) Handler {
+		for _, i := range interceptors {
+			if h := i(w, r, c); h != nil {
+				return h
+			}
+		}
+
+		return nil
+	}
+}
+
 func Generic401(w http.ResponseWriter, r *http.Request, c *Context) error {
 	http.Error(w, "Unauthorized", http.StatusUnauthorized)
 	return nil
@@ -47,30 +81,33 @@ func Generic403(w http.ResponseWriter, r *http.Request, c *Context) error {
 	return nil
 }
 
+func Generic429(w http.ResponseWriter, r *http.Request, c *Context) error {
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	return nil
+}
+
 func NewBasicAuthInterceptor(normal Handler) Interceptor {
 	return func(w http.ResponseWriter, r *http.Request, c *Context) Handler {
-		auth := r.Header.Get("Authorization")
-
-		if auth == "" {
+		if r.Header.Get("Authorization") == "" {
 			return Generic403
 		}
 
-		encoded := strings.Split(auth, "Basic ")[1]
-		reader := base64.NewDecoder(base64.StdEncoding, strings.NewReader(encoded))
-
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(reader)
-		s := buf.String()
-
-		credentials := strings.Split(s, ":")
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			return Generic401
+		}
 
 		// Authenticate now
-		u, err := Authenticate(credentials[0], credentials[1], c)
-		if err != nil {
+		u, err := Authenticate(username, password, c)
+		if err == ErrAccountLocked {
+			return Generic429
+		} else if err != nil {
 			return Generic401
 		}
 
 		c.User = u
+		c.audit(AuditLogin, u.Username, AuditSuccess, "basic auth")
+
 		return normal
 	}
 }
@@ -80,11 +117,27 @@ func NewAuthSessionInterceptor(normal, unauthorized Handler) Interceptor {
 		// Check if a user has been authenticated, otherwise
 		// redirect to the unauthorized view
 		uid := c.Session.Values["uid"]
+		if uid == nil {
+			// The session may have expired for a user that asked to
+			// be remembered
+			if u := c.loginRemembered(w, r); u != nil {
+				uid = u.Id
+			}
+		}
+
 		if uid != nil {
 			// run the handler and grab the error, and report it
-			if uid, ok := c.Session.Values["uid"].(bson.ObjectId); ok {
+			if uid, ok := uid.(bson.ObjectId); ok {
 				if c.Database.C("goat_users").Find(bson.M{"_id": uid}).One(&c.User); c.User != nil {
-					return normal
+					if c.User.Active() && c.decodeProfile(c.User) == nil && c.loadImpersonator() == nil {
+						return normal
+					}
+
+					// Disabled and deleted users are logged out, as are
+					// users whose profile can't be loaded and sessions
+					// impersonating on behalf of someone no longer allowed to
+					c.User = nil
+					c.ClearSession(w, r)
 				}
 			}
 		}
@@ -92,3 +145,55 @@ func NewAuthSessionInterceptor(normal, unauthorized Handler) Interceptor {
 		return unauthorized
 	}
 }
+
+// NewRequireRoleInterceptor only allows the request through to normal if the
+// authenticated user has one of the provided roles. It expects c.User to have
+// been populated by an earlier interceptor such as NewAuthSessionInterceptor.
+// If forbidden is nil, Generic403 is used.
+func NewRequireRoleInterceptor(roles []string, normal, forbidden Handler) Interceptor {
+	if forbidden == nil {
+		forbidden = Generic403
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, c *Context) Handler {
+		if c.User != nil && c.User.HasRole(roles...) {
+			return normal
+		}
+
+		return forbidden
+	}
+}
+
+// NewRequirePermissionInterceptor only allows the request through to normal
+// if the authenticated user has been granted all of the provided permissions.
+// If forbidden is nil, Generic403 is used.
+func NewRequirePermissionInterceptor(permissions []string, normal, forbidden Handler) Interceptor {
+	if forbidden == nil {
+		forbidden = Generic403
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, c *Context) Handler {
+		if c.User != nil && c.User.HasPermission(permissions...) {
+			return normal
+		}
+
+		return forbidden
+	}
+}
+
+// NewRequireVerifiedInterceptor only allows the request through to normal if
+// the authenticated user has verified their email address. If unverified is
+// nil, Generic403 is used.
+func NewRequireVerifiedInterceptor(normal, unverified Handler) Interceptor {
+	if unverified == nil {
+		unverified = Generic403
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, c *Context) Handler {
+		if c.User != nil && c.User.Verified {
+			return normal
+		}
+
+		return unverified
+	}
+}
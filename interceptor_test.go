@@ -0,0 +1,113 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// status runs an interceptor and the handler it returns, reporting the
+// response code, or 0 if the request was passed through.
+func status(i Interceptor, r *http.Request, c *Context) int {
+	w := httptest.NewRecorder()
+
+	h := i(w, r, c)
+	if h == nil {
+		return 0
+	}
+
+	h(w, r, c)
+	return w.Code
+}
+
+func TestBasicAuthMalformed(t *testing.T) {
+	i := NewBasicAuthInterceptor(func(w http.ResponseWriter, r *http.Request, c *Context) error {
+		t.Fatal("malformed credentials were let through")
+		return nil
+	})
+
+	tests := map[string]int{
+		"":                 http.StatusForbidden,
+		"Bearer x":         http.StatusUnauthorized,
+		"Basic !!!":        http.StatusUnauthorized,
+		"Basic YWxpY2U=":   http.StatusUnauthorized, // "alice", without a colon
+		"Basic":            http.StatusUnauthorized,
+		"Digest Basic abc": http.StatusUnauthorized,
+	}
+
+	for header, want := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+
+		if got := status(i, r, &Context{}); got != want {
+			t.Errorf("Authorization %q: got %d, want %d", header, got, want)
+		}
+	}
+}
+
+func TestChainInterceptors(t *testing.T) {
+	var ran []string
+
+	pass := func(name string) Interceptor {
+		return func(w http.ResponseWriter, r *http.Request, c *Context) Handler {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+
+	deny := func(name string) Interceptor {
+		return func(w http.ResponseWriter, r *http.Request, c *Context) Handler {
+			ran = append(ran, name)
+			return Generic401
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+
+	if got := status(ChainInterceptors(pass("a"), pass("b")), r, &Context{}); got != 0 {
+		t.Errorf("chain of pass-through interceptors responded with %d", got)
+	}
+
+	if want := []string{"a", "b"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+
+	ran = nil
+	if got := status(ChainInterceptors(pass("a"), deny("b"), deny("c")), r, &Context{}); got != http.StatusUnauthorized {
+		t.Errorf("chain responded with %d, want 401", got)
+	}
+
+	// The chain ends at the first interceptor that returns a Handler
+	if want := []string{"a", "b"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+}
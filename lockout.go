@@ -0,0 +1,403 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"errors"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAccountLocked = errors.New("too many failed login attempts, try again later")
+
+	// Used by EnableLockout when given an empty configuration. Zero Window
+	// and Duration fields of other configurations are taken from it. Client
+	// IPs aren't limited by default, since behind a proxy every request
+	// would share one.
+	DefaultLockoutConfig = LockoutConfig{
+		MaxUserAttempts: 5,
+		Window:          15 * time.Minute,
+		Duration:        15 * time.Minute,
+		Delay:           250 * time.Millisecond,
+		MaxDelay:        4 * time.Second,
+	}
+)
+
+type LockoutConfig struct {
+	// Failed attempts allowed for a single username, and from a single
+	// client IP, before further attempts are locked out. Zero disables
+	// the check.
+	MaxUserAttempts int
+	MaxIPAttempts   int
+	// Failures older than Window are forgotten
+	Window time.Duration
+	// How long a username or IP stays locked once it reaches its limit
+	Duration time.Duration
+	// Delay added to a failed attempt, doubled for every previous failure
+	// up to MaxDelay
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// Returns the client IP counted against MaxIPAttempts. Apps behind a
+	// trusted proxy should read the address it forwards; by default the
+	// request's RemoteAddr is used.
+	ClientIP func(*http.Request) string
+}
+
+// empty reports whether nothing but ClientIP is set.
+func (cfg LockoutConfig) empty() bool {
+	cfg.ClientIP = nil
+	return reflect.DeepEqual(cfg, LockoutConfig{})
+}
+
+// LoginAttempts tracks the recent failures for a single username or client
+// IP.
+type LoginAttempts struct {
+	Key         string    `bson:"_id"`
+	Failures    int       `bson:"failures"`
+	Last        time.Time `bson:"last"`
+	LockedUntil time.Time `bson:"locked_until"`
+}
+
+// AttemptStore persists LoginAttempts. Attempts returns nil without an error
+// when nothing has been recorded for a key.
+type AttemptStore interface {
+	Attempts(key string, c *Context) (*LoginAttempts, error)
+	// AddFailure records a failed attempt and returns the updated
+	// attempts. The count starts again if the previous failure is older
+	// than window. Concurrent failures must all be counted.
+	AddFailure(key string, window time.Duration, c *Context) (*LoginAttempts, error)
+	Lock(key string, until time.Time, c *Context) error
+	ClearAttempts(key string, c *Context) error
+}
+
+// MongoAttemptStore keeps login attempts in the goat_login_attempts
+// collection of the request's database.
+type MongoAttemptStore struct{}
+
+func (MongoAttemptStore) Attempts(key string, c *Context) (*LoginAttempts, error) {
+	var a LoginAttempts
+	err := c.Database.C("goat_login_attempts").FindId(key).One(&a)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// AddFailure updates the attempts atomically, so that parallel guesses
+// can't overwrite each other's counts.
+func (MongoAttemptStore) AddFailure(key string, window time.Duration, c *Context) (*LoginAttempts, error) {
+	col := c.Database.C("goat_login_attempts")
+	now := time.Now()
+	cutoff := now.Add(-window)
+
+	var a LoginAttempts
+
+	// Count the failure against recent ones
+	_, err := col.Find(bson.M{"_id": key, "last": bson.M{"$gte": cutoff}}).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"last": now}},
+		ReturnNew: true,
+	}, &a)
+	if err != mgo.ErrNotFound {
+		return &a, err
+	}
+
+	// Start the count again if the previous failures are too old
+	_, err = col.Find(bson.M{"_id": key, "last": bson.M{"$lt": cutoff}}).Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"failures": 1, "last": now}},
+		ReturnNew: true,
+	}, &a)
+	if err != mgo.ErrNotFound {
+		return &a, err
+	}
+
+	// This is the first failure, unless another request has recorded one
+	// in the meantime, in which case it's counted as well
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"last": now}},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+
+	_, err = col.FindId(key).Apply(change, &a)
+	if mgo.IsDup(err) {
+		// Lost the race to insert the document, which now exists
+		_, err = col.FindId(key).Apply(change, &a)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func (MongoAttemptStore) Lock(key string, until time.Time, c *Context) error {
+	return c.Database.C("goat_login_attempts").UpdateId(key, bson.M{"$set": bson.M{"locked_until": until}})
+}
+
+func (MongoAttemptStore) ClearAttempts(key string, c *Context) error {
+	_, err := c.Database.C("goat_login_attempts").RemoveAll(bson.M{"_id": key})
+	return err
+}
+
+// MemoryAttemptStore keeps login attempts in process, which is mostly
+// useful for tests.
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempts
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		attempts: make(map[string]LoginAttempts),
+	}
+}
+
+func (m *MemoryAttemptStore) Attempts(key string, c *Context) (*LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a, ok := m.attempts[key]; ok {
+		return &a, nil
+	}
+
+	return nil, nil
+}
+
+func (m *MemoryAttemptStore) AddFailure(key string, window time.Duration, c *Context) (*LoginAttempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	a, ok := m.attempts[key]
+	if !ok {
+		a = LoginAttempts{Key: key}
+	} else if now.Sub(a.Last) > window {
+		a.Failures = 0
+	}
+
+	a.Failures++
+	a.Last = now
+	m.attempts[key] = a
+
+	return &a, nil
+}
+
+func (m *MemoryAttemptStore) Lock(key string, until time.Time, c *Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a, ok := m.attempts[key]; ok {
+		a.LockedUntil = until
+		m.attempts[key] = a
+	}
+
+	return nil
+}
+
+func (m *MemoryAttemptStore) ClearAttempts(key string, c *Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+type Lockout struct {
+	Config LockoutConfig
+	Store  AttemptStore
+}
+
+// EnableLockout turns on brute-force protection for Authenticate and the
+// interceptors that use it. A config with nothing but ClientIP set uses
+// DefaultLockoutConfig. If store is nil the attempts are kept alongside
+// goat_users in MongoDB.
+func (g *Goat) EnableLockout(config LockoutConfig, store AttemptStore) {
+	if config.empty() {
+		clientIP := config.ClientIP
+		config = DefaultLockoutConfig
+		config.ClientIP = clientIP
+	}
+
+	// Without a window every failure would start the count again, and
+	// without a duration nothing would ever be locked
+	if config.Window <= 0 {
+		config.Window = DefaultLockoutConfig.Window
+	}
+
+	if config.Duration <= 0 {
+		config.Duration = DefaultLockoutConfig.Duration
+	}
+
+	if store == nil {
+		store = MongoAttemptStore{}
+	}
+
+	g.lockout = &Lockout{
+		Config: config,
+		Store:  store,
+	}
+}
+
+// UnlockUser clears the failed attempts recorded for a username.
+func UnlockUser(username string, c *Context) error {
+	if l := c.lockout(); l != nil {
+		return l.Store.ClearAttempts(userAttemptKey(username), c)
+	}
+
+	return nil
+}
+
+// UnlockIP clears the failed attempts recorded for a client IP.
+func UnlockIP(ip string, c *Context) error {
+	if l := c.lockout(); l != nil {
+		return l.Store.ClearAttempts(ipAttemptKey(ip), c)
+	}
+
+	return nil
+}
+
+type attemptKey struct {
+	key   string
+	limit int
+}
+
+func (l *Lockout) keys(username string, c *Context) []attemptKey {
+	keys := []attemptKey{{userAttemptKey(username), l.Config.MaxUserAttempts}}
+
+	if c.request != nil && l.Config.MaxIPAttempts > 0 {
+		ip := clientIP(c.request)
+		if l.Config.ClientIP != nil {
+			ip = l.Config.ClientIP(c.request)
+		}
+
+		if ip != "" {
+			keys = append(keys, attemptKey{ipAttemptKey(ip), l.Config.MaxIPAttempts})
+		}
+	}
+
+	return keys
+}
+
+// check returns ErrAccountLocked if the username or the client IP of the
+// request are currently locked out.
+func (l *Lockout) check(username string, c *Context) error {
+	if l == nil {
+		return nil
+	}
+
+	for _, k := range l.keys(username, c) {
+		a, err := l.Store.Attempts(k.key, c)
+		if err != nil {
+			return err
+		}
+
+		if a != nil && time.Now().Before(a.LockedUntil) {
+			return ErrAccountLocked
+		}
+	}
+
+	return nil
+}
+
+// fail records a failed attempt, locks out any key that has reached its
+// limit and then delays the response progressively.
+func (l *Lockout) fail(username string, c *Context) {
+	if l == nil {
+		return
+	}
+
+	failures := 0
+
+	for _, k := range l.keys(username, c) {
+		a, err := l.Store.AddFailure(k.key, l.Config.Window, c)
+		if err != nil {
+			continue
+		}
+
+		if k.limit > 0 && a.Failures >= k.limit {
+			l.Store.Lock(k.key, time.Now().Add(l.Config.Duration), c)
+		}
+
+		if a.Failures > failures {
+			failures = a.Failures
+		}
+	}
+
+	time.Sleep(l.delay(failures))
+}
+
+// succeed forgets the failures for a username after a successful login. The
+// client IP is left alone so that one valid account can't be used to reset
+// the count for an address that is guessing at others.
+func (l *Lockout) succeed(username string, c *Context) {
+	if l == nil {
+		return
+	}
+
+	l.Store.ClearAttempts(userAttemptKey(username), c)
+}
+
+func (l *Lockout) delay(failures int) time.Duration {
+	d := l.Config.Delay
+	for i := 1; i < failures && d < l.Config.MaxDelay; i++ {
+		d *= 2
+	}
+
+	if l.Config.MaxDelay > 0 && d > l.Config.MaxDelay {
+		d = l.Config.MaxDelay
+	}
+
+	return d
+}
+
+func userAttemptKey(username string) string {
+	return "user:" + NormalizeUsername(username)
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
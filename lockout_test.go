@@ -0,0 +1,127 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestLockoutWithoutWindow(t *testing.T) {
+	g := New(nil)
+	g.EnableLockout(LockoutConfig{MaxUserAttempts: 3}, NewMemoryAttemptStore())
+	c := &Context{goat: g}
+
+	for i := 0; i < 3; i++ {
+		if err := g.lockout.check("alice", c); err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+
+		g.lockout.fail("alice", c)
+	}
+
+	if err := g.lockout.check("Alice", c); err != ErrAccountLocked {
+		t.Fatalf("check after 3 failures = %v, want ErrAccountLocked", err)
+	}
+
+	if err := UnlockUser("alice", c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := g.lockout.check("alice", c); err != nil {
+		t.Fatalf("check after unlocking = %v", err)
+	}
+}
+
+func TestLockoutCountsParallelFailures(t *testing.T) {
+	g := New(nil)
+	g.EnableLockout(LockoutConfig{MaxUserAttempts: 50}, NewMemoryAttemptStore())
+	c := &Context{goat: g}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.lockout.fail("alice", c)
+		}()
+	}
+	wg.Wait()
+
+	a, err := g.lockout.Store.Attempts(userAttemptKey("alice"), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Failures != 20 {
+		t.Fatalf("recorded %d failures, want 20", a.Failures)
+	}
+}
+
+func TestLockoutClientIP(t *testing.T) {
+	g := New(nil)
+	g.EnableLockout(LockoutConfig{
+		MaxIPAttempts: 2,
+		ClientIP: func(r *http.Request) string {
+			return r.Header.Get("X-Forwarded-For")
+		},
+	}, NewMemoryAttemptStore())
+
+	proxied := func(ip string) *Context {
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.Header.Set("X-Forwarded-For", ip)
+		return &Context{goat: g, request: r}
+	}
+
+	g.lockout.fail("alice", proxied("10.0.0.1"))
+	g.lockout.fail("bob", proxied("10.0.0.1"))
+
+	if err := g.lockout.check("carol", proxied("10.0.0.1")); err != ErrAccountLocked {
+		t.Fatalf("check from a locked IP = %v, want ErrAccountLocked", err)
+	}
+
+	if err := g.lockout.check("carol", proxied("10.0.0.2")); err != nil {
+		t.Fatalf("check from another IP behind the same proxy = %v", err)
+	}
+}
+
+func TestDefaultLockoutIgnoresIP(t *testing.T) {
+	g := New(nil)
+	g.EnableLockout(LockoutConfig{}, NewMemoryAttemptStore())
+	g.lockout.Config.Delay = 0
+
+	for _, username := range []string{"a", "b", "c", "d", "e", "f"} {
+		g.lockout.fail(username, &Context{goat: g, request: httptest.NewRequest("POST", "/login", nil)})
+	}
+
+	c := &Context{goat: g, request: httptest.NewRequest("POST", "/login", nil)}
+	if err := g.lockout.check("g", c); err != nil {
+		t.Fatalf("check after failures for other users = %v", err)
+	}
+}
@@ -28,7 +28,9 @@
 package goat
 
 import (
+	"errors"
 	"labix.org/v2/mgo"
+	"log"
 	"net/http"
 	"net/url"
 )
@@ -70,8 +72,17 @@ func (g *Goat) NewDatabaseMiddleware(host, name string) Middleware {
 
 	g.dbname = name
 
+	// Clashing usernames only affect those accounts, so the app can still
+	// start
+	if err := EnsureUserIndexes(s.DB(name)); errors.As(err, new(*UsernameCollisionError)) {
+		log.Print(err)
+	} else if err != nil {
+		panic(err.Error())
+	}
+
 	return func(r *http.Request, c *Context) error {
 		c.Database = g.dbsession.Copy().DB(name)
+		c.limitDatabase(c.Database.Session)
 		return nil
 	}
 }
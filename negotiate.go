@@ -0,0 +1,236 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Renderer writes a representation of a Handler's data for Negotiate.
+type Renderer interface {
+	// The media type produced, e.g. "application/json"
+	MediaType() string
+	Render(w io.Writer, c *Context, data interface{}) error
+}
+
+// CSVMarshaler can be implemented by data rendered as CSV, which otherwise
+// has to be a [][]string.
+type CSVMarshaler interface {
+	MarshalCSV() ([][]string, error)
+}
+
+var (
+	JSONRenderer Renderer = jsonRenderer{}
+	XMLRenderer  Renderer = xmlRenderer{}
+	CSVRenderer  Renderer = csvRenderer{}
+
+	// Used by Negotiate when neither the call nor the app provide renderers
+	defaultRenderers = []Renderer{JSONRenderer, XMLRenderer}
+)
+
+// HTMLRenderer renders data with the named template from the app's
+// registered templates.
+func HTMLRenderer(name string) Renderer {
+	return htmlRenderer(name)
+}
+
+// RegisterRenderer adds a renderer that Negotiate offers when it isn't
+// given any. Apps that don't register any get JSON and XML.
+func (g *Goat) RegisterRenderer(r Renderer) {
+	g.renderers = append(g.renderers, r)
+}
+
+// Negotiate responds with the representation of data that best matches the
+// request's Accept header, choosing from offers or, if there are none, the
+// app's registered renderers. Ties go to the renderer offered first. If
+// nothing is acceptable a 406 *HTTPError is returned.
+//
+//	return c.Negotiate(http.StatusOK, post, goat.HTMLRenderer("post.html"), goat.JSONRenderer)
+func (c *Context) Negotiate(status int, data interface{}, offers ...Renderer) error {
+	if len(offers) == 0 {
+		offers = defaultRenderers
+		if c.goat != nil && len(c.goat.renderers) > 0 {
+			offers = c.goat.renderers
+		}
+	}
+
+	c.response.Header().Add("Vary", "Accept")
+
+	r := negotiate(c.request.Header.Get("Accept"), offers)
+	if r == nil {
+		return NewHTTPError(http.StatusNotAcceptable, "")
+	}
+
+	var buf bytes.Buffer
+	if err := r.Render(&buf, c, data); err != nil {
+		return err
+	}
+
+	return c.write(status, r.MediaType()+"; charset=utf-8", buf.Bytes())
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiate picks the offer with the highest quality in an Accept header,
+// using the quality of the most specific range that matches each offer.
+func negotiate(accept string, offers []Renderer) Renderer {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	ranges := parseAccept(accept)
+
+	var best Renderer
+	bestQ := 0.0
+
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+
+		for _, ar := range ranges {
+			if s := matchMediaType(ar.mediaType, offer.MediaType()); s > specificity {
+				q, specificity = ar.q, s
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+func parseAccept(accept string) (ranges []acceptRange) {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+
+		ar := acceptRange{
+			mediaType: strings.ToLower(strings.TrimSpace(params[0])),
+			q:         1,
+		}
+
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && kv[0] == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					ar.q = q
+				}
+			}
+		}
+
+		if ar.mediaType != "" {
+			ranges = append(ranges, ar)
+		}
+	}
+
+	return
+}
+
+// matchMediaType returns how specifically a media range matches a media
+// type: 2 for an exact match, 1 for "type/*", 0 for "*/*" and -1 if it
+// doesn't match.
+func matchMediaType(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") &&
+		strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+
+	return -1
+}
+
+type jsonRenderer struct{}
+
+func (jsonRenderer) MediaType() string {
+	return "application/json"
+}
+
+func (jsonRenderer) Render(w io.Writer, c *Context, data interface{}) error {
+	return json.NewEncoder(w).Encode(data)
+}
+
+type xmlRenderer struct{}
+
+func (xmlRenderer) MediaType() string {
+	return "application/xml"
+}
+
+func (xmlRenderer) Render(w io.Writer, c *Context, data interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	return xml.NewEncoder(w).Encode(data)
+}
+
+type csvRenderer struct{}
+
+func (csvRenderer) MediaType() string {
+	return "text/csv"
+}
+
+func (csvRenderer) Render(w io.Writer, c *Context, data interface{}) (err error) {
+	var records [][]string
+
+	switch d := data.(type) {
+	case CSVMarshaler:
+		if records, err = d.MarshalCSV(); err != nil {
+			return
+		}
+	case [][]string:
+		records = d
+	default:
+		return errors.New("goat: CSV data must be a [][]string or a CSVMarshaler")
+	}
+
+	return csv.NewWriter(w).WriteAll(records)
+}
+
+type htmlRenderer string
+
+func (htmlRenderer) MediaType() string {
+	return "text/html"
+}
+
+func (h htmlRenderer) Render(w io.Writer, c *Context, data interface{}) error {
+	return c.executeTemplate(w, string(h), data)
+}
@@ -0,0 +1,99 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseAccept(t *testing.T) {
+	got := parseAccept("text/html, Application/JSON;q=0.5 ,*/*; q=0.1, ;q=1, text/csv;q=bad")
+	want := []acceptRange{
+		{"text/html", 1},
+		{"application/json", 0.5},
+		{"*/*", 0.1},
+		{"text/csv", 1},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []Renderer{JSONRenderer, XMLRenderer, CSVRenderer}
+
+	tests := []struct {
+		accept string
+		want   Renderer
+	}{
+		{"", JSONRenderer},
+		{"*/*", JSONRenderer},
+		{"application/xml", XMLRenderer},
+		{"application/json;q=0.5, application/xml", XMLRenderer},
+		{"text/*, application/json;q=0.9", CSVRenderer},
+		// The most specific range decides an offer's quality
+		{"*/*, application/json;q=0", XMLRenderer},
+		{"application/*;q=0.2, application/xml;q=0.1", JSONRenderer},
+		{"image/png", nil},
+		{"application/json;q=0", nil},
+	}
+
+	for _, test := range tests {
+		if got := negotiate(test.accept, offers); got != test.want {
+			t.Errorf("negotiate(%q) = %v, want %v", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestContextNegotiate(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/xml")
+	w := httptest.NewRecorder()
+	c := &Context{request: r, response: w}
+
+	if err := c.Negotiate(http.StatusOK, []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := w.Header().Get("Content-Type"); got != "application/xml; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	if got := w.Header().Get("Vary"); got != "Accept" {
+		t.Errorf("Vary = %q", got)
+	}
+
+	r.Header.Set("Accept", "text/csv")
+	err := c.Negotiate(http.StatusOK, nil, JSONRenderer)
+	if herr, ok := err.(*HTTPError); !ok || herr.Status != http.StatusNotAcceptable {
+		t.Errorf("unacceptable request returned %v, want a 406 *HTTPError", err)
+	}
+}
@@ -0,0 +1,75 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strconv"
+)
+
+// Param returns a variable from the route's path, e.g. "id" for a route
+// registered as "/posts/{id}".
+func (c *Context) Param(name string) string {
+	if c.request == nil {
+		return ""
+	}
+
+	return mux.Vars(c.request)[name]
+}
+
+// ParamInt returns a path variable as an int, or a 400 *HTTPError if it
+// isn't one.
+func (c *Context) ParamInt(name string) (int, error) {
+	n, err := strconv.Atoi(c.Param(name))
+	if err != nil {
+		return 0, paramError(name, "must be a whole number")
+	}
+
+	return n, nil
+}
+
+// ParamObjectId returns a path variable as a bson.ObjectId, or a 400
+// *HTTPError if it isn't a valid hex id.
+func (c *Context) ParamObjectId(name string) (bson.ObjectId, error) {
+	p := c.Param(name)
+	if !bson.IsObjectIdHex(p) {
+		return "", paramError(name, "must be a valid id")
+	}
+
+	return bson.ObjectIdHex(p), nil
+}
+
+func paramError(name, msg string) *HTTPError {
+	return &HTTPError{
+		Status:  http.StatusBadRequest,
+		Message: fmt.Sprintf("invalid %s: %s", name, msg),
+		Fields:  map[string]string{name: msg},
+	}
+}
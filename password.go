@@ -0,0 +1,236 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+type PasswordViolation string
+
+const (
+	PasswordTooShort         PasswordViolation = "too_short"
+	PasswordTooLong          PasswordViolation = "too_long"
+	PasswordMissingUpper     PasswordViolation = "missing_upper"
+	PasswordMissingLower     PasswordViolation = "missing_lower"
+	PasswordMissingDigit     PasswordViolation = "missing_digit"
+	PasswordMissingSymbol    PasswordViolation = "missing_symbol"
+	PasswordContainsUsername PasswordViolation = "contains_username"
+	PasswordBreached         PasswordViolation = "breached"
+)
+
+// PasswordError is returned when a password doesn't satisfy a
+// PasswordPolicy, and lists every rule that it broke.
+type PasswordError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordError) Error() string {
+	v := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		v[i] = string(violation)
+	}
+
+	return "password does not meet policy: " + strings.Join(v, ", ")
+}
+
+// Has reports whether the password broke the given rule.
+func (e *PasswordError) Has(v PasswordViolation) bool {
+	for _, violation := range e.Violations {
+		if violation == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	// Zero means no maximum. Note that bcrypt only considers the first 72
+	// bytes of a password.
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Rejects passwords that contain the username, ignoring case
+	DisallowUsername bool
+	// Rejects passwords found in a list of known breached passwords
+	Breached *BreachedList
+	// The bcrypt cost used to hash passwords when the app hasn't set a
+	// PasswordHasher, bcrypt.DefaultCost if zero
+	Cost int
+}
+
+// DefaultPasswordPolicy is used by User.SetPassword, and by apps that haven't
+// configured their own policy with SetPasswordPolicy.
+var DefaultPasswordPolicy = &PasswordPolicy{
+	MinLength: 1,
+	Cost:      bcrypt.DefaultCost,
+}
+
+// SetPasswordPolicy sets the policy that NewUser, ResetPassword and
+// User.ChangePassword enforce for this app.
+func (g *Goat) SetPasswordPolicy(p *PasswordPolicy) {
+	g.passwordPolicy = p
+}
+
+// Validate checks a password against the policy, returning a *PasswordError
+// if it breaks any of the rules.
+func (p *PasswordPolicy) Validate(username, password string) error {
+	var v []PasswordViolation
+
+	if n := len([]rune(password)); n < p.MinLength {
+		v = append(v, PasswordTooShort)
+	} else if p.MaxLength > 0 && n > p.MaxLength {
+		v = append(v, PasswordTooLong)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		v = append(v, PasswordMissingUpper)
+	}
+
+	if p.RequireLower && !lower {
+		v = append(v, PasswordMissingLower)
+	}
+
+	if p.RequireDigit && !digit {
+		v = append(v, PasswordMissingDigit)
+	}
+
+	if p.RequireSymbol && !symbol {
+		v = append(v, PasswordMissingSymbol)
+	}
+
+	if p.DisallowUsername && username != "" &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		v = append(v, PasswordContainsUsername)
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		v = append(v, PasswordBreached)
+	}
+
+	if v != nil {
+		return &PasswordError{Violations: v}
+	}
+
+	return nil
+}
+
+func (p *PasswordPolicy) cost() int {
+	if p.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+
+	return p.Cost
+}
+
+// BreachedList is an offline set of passwords known to have been exposed in
+// breaches.
+type BreachedList struct {
+	hashes map[string]struct{}
+}
+
+// LoadBreachedList reads a list of breached passwords from a local file. Each
+// line is either a plaintext password or the hex SHA-1 of one, optionally
+// followed by ":count" as in the Pwned Passwords downloads.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &BreachedList{
+		hashes: make(map[string]struct{}),
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if hash := strings.SplitN(line, ":", 2)[0]; isSHA1Hex(hash) {
+			b.hashes[strings.ToUpper(hash)] = struct{}{}
+		} else {
+			b.hashes[sha1Hex(line)] = struct{}{}
+		}
+	}
+
+	return b, scanner.Err()
+}
+
+func (b *BreachedList) Contains(password string) bool {
+	_, ok := b.hashes[sha1Hex(password)]
+	return ok
+}
+
+func (b *BreachedList) Len() int {
+	return len(b.hashes)
+}
+
+func sha1Hex(s string) string {
+	return fmt.Sprintf("%X", sha1.Sum([]byte(s)))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,106 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := &PasswordPolicy{
+		MinLength:        8,
+		MaxLength:        20,
+		RequireUpper:     true,
+		RequireLower:     true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		DisallowUsername: true,
+	}
+
+	tests := []struct {
+		password   string
+		violations []PasswordViolation
+	}{
+		{"Tr0ub4dor&3", nil},
+		{"Ab1!", []PasswordViolation{PasswordTooShort}},
+		{"Ab1!Ab1!Ab1!Ab1!Ab1!Ab1!", []PasswordViolation{PasswordTooLong}},
+		{"abcdefgh", []PasswordViolation{PasswordMissingUpper, PasswordMissingDigit, PasswordMissingSymbol}},
+		{"ABCDEFG1!", []PasswordViolation{PasswordMissingLower}},
+		{"xALICEx1!", []PasswordViolation{PasswordContainsUsername}},
+	}
+
+	for _, test := range tests {
+		err := policy.Validate("alice", test.password)
+		if test.violations == nil {
+			if err != nil {
+				t.Errorf("Validate(%q) = %v", test.password, err)
+			}
+
+			continue
+		}
+
+		perr, ok := err.(*PasswordError)
+		if !ok {
+			t.Errorf("Validate(%q) = %v, want a *PasswordError", test.password, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(perr.Violations, test.violations) {
+			t.Errorf("Validate(%q) violations = %v, want %v", test.password, perr.Violations, test.violations)
+		}
+	}
+}
+
+func TestBreachedList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	list := fmt.Sprintf("password1\n%X:42\n", sha1.Sum([]byte("letmein")))
+	if err := os.WriteFile(path, []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	breached, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &PasswordPolicy{Breached: breached}
+
+	for _, password := range []string{"password1", "letmein"} {
+		if err, ok := policy.Validate("", password).(*PasswordError); !ok || !err.Has(PasswordBreached) {
+			t.Errorf("Validate(%q) = %v, want it reported as breached", password, err)
+		}
+	}
+
+	if err := policy.Validate("", "not in the list"); err != nil {
+		t.Errorf("Validate of an unlisted password = %v", err)
+	}
+}
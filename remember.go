@@ -0,0 +1,275 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	rememberCookie = "goat_remember"
+
+	// Used when Config.RememberDuration isn't set
+	DefaultRememberDuration = 30 * 24 * time.Hour
+
+	// How long a replaced validator is still accepted, since a browser
+	// sends several requests with the same cookie at once when its session
+	// has expired
+	rememberGrace = 30 * time.Second
+)
+
+// RememberToken is the server side half of a remember-me cookie. The cookie
+// holds the selector, used to look the token up, and a validator that is
+// only stored hashed. Validators are replaced every time they are used, and
+// the previous one is kept for a short while for requests already in flight.
+type RememberToken struct {
+	Id        bson.ObjectId `bson:"_id"`
+	Selector  string        `bson:"selector"`
+	Validator []byte        `bson:"validator"`
+	Previous  []byte        `bson:"previous,omitempty"`
+	Rotated   time.Time     `bson:"rotated,omitempty"`
+	UserId    bson.ObjectId `bson:"uid"`
+	Expires   time.Time     `bson:"expires"`
+}
+
+// Remember sets a long lived cookie that NewAuthSessionInterceptor uses to
+// log the user back in once their session has expired.
+func (u *User) Remember(w http.ResponseWriter, r *http.Request, c *Context) error {
+	selector, err := randomToken(12)
+	if err != nil {
+		return err
+	}
+
+	t := &RememberToken{
+		Id:       bson.NewObjectId(),
+		Selector: selector,
+		UserId:   u.Id,
+	}
+
+	return t.issue(w, r, c)
+}
+
+// RevokeRememberTokens logs the user out of every device that they asked to
+// be remembered on.
+func (u *User) RevokeRememberTokens(c *Context) error {
+	_, err := c.Database.C("goat_remember_tokens").RemoveAll(bson.M{"uid": u.Id})
+	return err
+}
+
+// issue generates a validator for a new token, saves it and sets the
+// cookie.
+func (t *RememberToken) issue(w http.ResponseWriter, r *http.Request, c *Context) error {
+	validator, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	t.Validator = hashValidator(validator)
+	t.Expires = time.Now().Add(c.rememberDuration())
+
+	if _, err = c.Database.C("goat_remember_tokens").UpsertId(t.Id, t); err != nil {
+		return err
+	}
+
+	setRememberCookie(w, r, t.Selector+":"+validator, t.Expires)
+
+	return nil
+}
+
+// rotate replaces the validator of a token that has just been used. If a
+// parallel request has already replaced it, the cookie that request sets is
+// kept.
+func (t *RememberToken) rotate(w http.ResponseWriter, r *http.Request, c *Context) error {
+	validator, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expires := now.Add(c.rememberDuration())
+
+	err = c.Database.C("goat_remember_tokens").Update(bson.M{"_id": t.Id, "validator": t.Validator}, bson.M{
+		"$set": bson.M{
+			"validator": hashValidator(validator),
+			"previous":  t.Validator,
+			"rotated":   now,
+			"expires":   expires,
+		},
+	})
+	if err == mgo.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	setRememberCookie(w, r, t.Selector+":"+validator, expires)
+
+	return nil
+}
+
+// loginRemembered logs in the user of a valid remember-me cookie, rotating
+// its validator. A cookie with a known selector but the wrong validator
+// means the token was stolen and already used, so every token belonging to
+// that user is revoked. The validator that was just replaced is still
+// accepted for rememberGrace.
+func (c *Context) loginRemembered(w http.ResponseWriter, r *http.Request) *User {
+	cookie, err := r.Cookie(rememberCookie)
+	if err != nil {
+		return nil
+	}
+
+	parts := strings.SplitN(cookie.Value, ":", 2)
+	if len(parts) != 2 {
+		expireRememberCookie(w)
+		return nil
+	}
+
+	var t RememberToken
+	if err = c.Database.C("goat_remember_tokens").Find(bson.M{"selector": parts[0]}).One(&t); err != nil {
+		expireRememberCookie(w)
+		return nil
+	}
+
+	var u *User
+	c.Database.C("goat_users").FindId(t.UserId).One(&u)
+
+	validator := hashValidator(parts[1])
+	current := subtle.ConstantTimeCompare(t.Validator, validator) == 1
+	previous := !current && time.Since(t.Rotated) < rememberGrace &&
+		subtle.ConstantTimeCompare(t.Previous, validator) == 1
+
+	if !current && !previous {
+		c.Database.C("goat_remember_tokens").RemoveAll(bson.M{"uid": t.UserId})
+		expireRememberCookie(w)
+
+		if u != nil {
+			c.audit(AuditRememberTheft, u.Username, AuditFailure, "remember-me token reused, all tokens revoked")
+		}
+
+		return nil
+	}
+
+	if u == nil || !u.Active() || time.Now().After(t.Expires) {
+		c.Database.C("goat_remember_tokens").RemoveId(t.Id)
+		expireRememberCookie(w)
+		return nil
+	}
+
+	if current {
+		if err = t.rotate(w, r, c); err != nil {
+			return nil
+		}
+	}
+
+	c.Session.Values["uid"] = u.Id
+	c.Session.Save(r, w)
+
+	c.audit(AuditLogin, u.Username, AuditSuccess, "remember-me")
+
+	return u
+}
+
+// forgetRemembered removes the remember-me token of the request, if any.
+func (c *Context) forgetRemembered(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(rememberCookie)
+	if err != nil {
+		return
+	}
+
+	if c.Database != nil {
+		selector := strings.SplitN(cookie.Value, ":", 2)[0]
+		c.Database.C("goat_remember_tokens").RemoveAll(bson.M{"selector": selector})
+	}
+
+	expireRememberCookie(w)
+}
+
+func (c *Context) rememberDuration() time.Duration {
+	if c.goat == nil || c.goat.Config.RememberDuration == 0 {
+		return DefaultRememberDuration
+	}
+
+	return c.goat.Config.RememberDuration
+}
+
+func ensureRememberIndexes(db *mgo.Database) error {
+	indexes := []mgo.Index{
+		{Key: []string{"selector"}, Unique: true},
+		{Key: []string{"uid"}},
+		{Key: []string{"expires"}, ExpireAfter: time.Second},
+	}
+
+	for _, i := range indexes {
+		i.Background = true
+		if err := db.C("goat_remember_tokens").EnsureIndex(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setRememberCookie(w http.ResponseWriter, r *http.Request, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+}
+
+func expireRememberCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   rememberCookie,
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
+
+func hashValidator(validator string) []byte {
+	h := sha256.Sum256([]byte(validator))
+	return h[:]
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
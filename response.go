@@ -0,0 +1,138 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+)
+
+var ErrNoTemplates = errors.New("no templates have been registered")
+
+// RegisterTemplates sets the templates that Context.Render executes, usually
+// the result of ParseTemplates. The app keeps a clone with its url, absURL and
+// asset functions bound, so the same templates can be registered on several
+// apps. Templates that have already been executed can't be cloned. Use
+// LoadTemplates to have goat parse them, and re-parse them in development.
+func (g *Goat) RegisterTemplates(t *template.Template) error {
+	clone, err := t.Clone()
+	if err != nil {
+		return err
+	}
+
+	g.templates = &templateSet{t: clone.Funcs(g.appFuncs())}
+
+	return nil
+}
+
+// JSON writes v as the JSON response with the given status. Nothing is
+// written if v can't be encoded, so the error can be returned from the
+// Handler as usual.
+func (c *Context) JSON(status int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.write(status, "application/json; charset=utf-8", append(data, '\n'))
+}
+
+// XML writes v as the XML response with the given status.
+func (c *Context) XML(status int, v interface{}) error {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.write(status, "application/xml; charset=utf-8", append([]byte(xml.Header), data...))
+}
+
+// Render executes the named template from the app's registered templates
+// and writes it as the response. If the template fails nothing is written.
+func (c *Context) Render(name string, data interface{}) error {
+	var buf bytes.Buffer
+	if err := c.executeTemplate(&buf, name, data); err != nil {
+		return err
+	}
+
+	return c.write(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (c *Context) executeTemplate(w io.Writer, name string, data interface{}) error {
+	if c.goat == nil {
+		return ErrNoTemplates
+	}
+
+	return c.goat.ExecuteTemplate(w, name, data)
+}
+
+// ExecuteTemplate executes one of the app's registered templates outside of
+// a request, such as to render an email. Links in emails should be built
+// with the absURL template function.
+func (g *Goat) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	if g.templates == nil {
+		return ErrNoTemplates
+	}
+
+	t, err := g.templates.template(g.Config.Development)
+	if err != nil {
+		return err
+	}
+
+	return t.ExecuteTemplate(w, name, data)
+}
+
+// Redirect sends the client to the named route, see Goat.Reverse.
+func (c *Context) Redirect(routeName string, params ...string) error {
+	u, err := c.goat.Reverse(routeName, params...)
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(c.response, c.request, u.String(), http.StatusFound)
+
+	return nil
+}
+
+// NoContent responds with a 204.
+func (c *Context) NoContent() error {
+	c.response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (c *Context) write(status int, contentType string, body []byte) error {
+	c.response.Header().Set("Content-Type", contentType)
+	c.response.WriteHeader(status)
+
+	_, err := c.response.Write(body)
+	return err
+}
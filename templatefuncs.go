@@ -28,26 +28,120 @@
 package goat
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io/fs"
 	"labix.org/v2/mgo/bson"
+	"net/url"
 	"reflect"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 var (
+	// goat's built-in template functions, available to every template it
+	// parses. This is never modified; apps add their own functions with
+	// Goat.AddTemplateFuncs.
 	funcMap = template.FuncMap{
 		"objectIdHex": ObjectIdHex,
 		"eq":          eq,
+		"date":        date,
+		"timeAgo":     timeAgo,
+		"truncate":    truncate,
+		"pluralize":   pluralize,
+		"default":     defaultValue,
+		"dict":        dict,
+		"list":        list,
+		"json":        toJSON,
+		"safeHTML":    safeHTML,
+		"safeURL":     safeURL,
+		"currentUser": currentUser,
+		"csrf":        csrf,
+		"csrfField":   csrfField,
+
+		// Bound to the app by Goat.TemplateFuncs, and by RegisterTemplates
+		// for templates parsed by ParseTemplates
+		"url":    unbound("url"),
+		"absURL": unbound("absURL"),
+		"asset":  unbound("asset"),
 	}
 )
 
-func ParseTemplates(name, path string, funcs template.FuncMap, delims []string) *template.Template {
-	if funcs != nil {
-		for k, v := range funcs {
-			funcMap[k] = v
-		}
+// unbound stands in for a function that needs the app, so that templates
+// using it can be parsed before the app is known.
+func unbound(name string) func(...interface{}) (interface{}, error) {
+	return func(...interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("goat: %s is only available to templates registered with an app", name)
+	}
+}
+
+// appFuncs returns the template functions bound to the app.
+func (g *Goat) appFuncs() template.FuncMap {
+	return template.FuncMap{
+		"url":    g.urlFunc,
+		"absURL": g.absURLFunc,
+		"asset":  g.Asset,
+	}
+}
+
+// funcRegistry holds the template functions added to an app.
+type funcRegistry struct {
+	mu    sync.RWMutex
+	funcs template.FuncMap
+}
+
+// AddTemplateFuncs makes funcs available to the templates the app loads from
+// then on, replacing built-ins and previously added functions of the same
+// name. Functions passed to LoadTemplates or LoadLayouts in turn override
+// these for that set of templates only.
+//
+// Templates already loaded keep the functions they were parsed with, except
+// in development where they pick up the new ones when next re-parsed.
+func (g *Goat) AddTemplateFuncs(funcs template.FuncMap) {
+	g.funcs.mu.Lock()
+	defer g.funcs.mu.Unlock()
+
+	if g.funcs.funcs == nil {
+		g.funcs.funcs = make(template.FuncMap)
 	}
 
-	t := template.New(name).Funcs(funcMap)
+	for k, v := range funcs {
+		g.funcs.funcs[k] = v
+	}
+}
+
+// TemplateFuncs returns a copy of the functions available to the app's
+// templates: goat's built-ins and those added with AddTemplateFuncs.
+func (g *Goat) TemplateFuncs() template.FuncMap {
+	g.funcs.mu.RLock()
+	defer g.funcs.mu.RUnlock()
+
+	funcs := make(template.FuncMap, len(funcMap)+len(g.funcs.funcs))
+
+	for k, v := range funcMap {
+		funcs[k] = v
+	}
+
+	for k, v := range g.appFuncs() {
+		funcs[k] = v
+	}
+
+	for k, v := range g.funcs.funcs {
+		funcs[k] = v
+	}
+
+	return funcs
+}
+
+// ParseTemplates parses the templates matching path with goat's built-in
+// functions and funcs, panicking if they can't be parsed. funcs only applies
+// to the returned templates; use Goat.LoadTemplates for templates that can
+// use the app's functions.
+func ParseTemplates(name, path string, funcs template.FuncMap, delims []string) *template.Template {
+	t := template.New(name).Funcs(funcMap).Funcs(funcs)
 
 	if delims != nil {
 		t.Delims(delims[0], delims[1])
@@ -58,6 +152,20 @@ func ParseTemplates(name, path string, funcs template.FuncMap, delims []string)
 	return template.Must(t, err)
 }
 
+// ParseTemplatesFS is like ParseTemplates, but reads the templates from fsys,
+// such as an embed.FS.
+func ParseTemplatesFS(name string, fsys fs.FS, pattern string, funcs template.FuncMap, delims []string) *template.Template {
+	t := template.New(name).Funcs(funcMap).Funcs(funcs)
+
+	if delims != nil {
+		t.Delims(delims[0], delims[1])
+	}
+
+	t, err := t.ParseFS(fsys, pattern)
+
+	return template.Must(t, err)
+}
+
 // Via Russ Cox: http://goo.gl/GJUl1
 func eq(args ...interface{}) bool {
 	if len(args) == 0 {
@@ -85,3 +193,252 @@ func eq(args ...interface{}) bool {
 func ObjectIdHex(id bson.ObjectId) string {
 	return id.Hex()
 }
+
+// urlFunc reverses a route for the url template function. Params may be of
+// any type, and may be followed by a query built with dict:
+//
+//	{{url "user" "id" .Id}}
+//	{{url "search" (dict "q" .Term "page" 2)}}
+func (g *Goat) urlFunc(name string, params ...interface{}) (string, error) {
+	u, err := g.templateURL(name, params)
+	if err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+// absURLFunc is like urlFunc, but makes the URL absolute with Goat.Absolute.
+func (g *Goat) absURLFunc(name string, params ...interface{}) (string, error) {
+	u, err := g.templateURL(name, params)
+	if err != nil {
+		return "", err
+	}
+
+	if u, err = g.Absolute(u); err != nil {
+		return "", err
+	}
+
+	return u.String(), nil
+}
+
+func (g *Goat) templateURL(name string, params []interface{}) (*url.URL, error) {
+	var query url.Values
+
+	// Params come in pairs, so a trailing one is the query
+	if n := len(params); n%2 == 1 {
+		switch q := params[n-1].(type) {
+		case url.Values:
+			query = q
+		case map[string]interface{}:
+			query = make(url.Values, len(q))
+			for k, v := range q {
+				query.Set(k, templateParam(v))
+			}
+		default:
+			return nil, fmt.Errorf("goat: expected a query after the route parameters, got %T", q)
+		}
+
+		params = params[:n-1]
+	}
+
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = templateParam(p)
+	}
+
+	return g.ReverseQuery(name, query, pairs...)
+}
+
+func templateParam(p interface{}) string {
+	if id, ok := p.(bson.ObjectId); ok {
+		return id.Hex()
+	}
+
+	return fmt.Sprint(p)
+}
+
+// currentUser returns the logged in user of a Context, for templates that
+// are handed one:
+//
+//	{{with currentUser .Context}}{{.Username}}{{end}}
+func currentUser(c *Context) *User {
+	if c == nil {
+		return nil
+	}
+
+	return c.User
+}
+
+// csrf returns the CSRF token of a Context, see Context.CSRFToken.
+func csrf(c *Context) (string, error) {
+	if c == nil {
+		return "", ErrNoSession
+	}
+
+	return c.CSRFToken()
+}
+
+// csrfField returns a hidden form input holding the CSRF token of a Context.
+func csrfField(c *Context) (template.HTML, error) {
+	token, err := csrf(c)
+	if err != nil {
+		return "", err
+	}
+
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		CSRFField, template.HTMLEscapeString(token))), nil
+}
+
+// date formats a time.Time or *time.Time with a time.Format layout, e.g.
+// {{.Created | date "Jan 2, 2006"}}. Zero and nil times format as "".
+func date(layout string, t interface{}) string {
+	tm, ok := toTime(t)
+	if !ok {
+		return ""
+	}
+
+	return tm.Format(layout)
+}
+
+// timeAgo describes a time relative to now, e.g. "5 minutes ago" or
+// "in 2 days".
+func timeAgo(t interface{}) string {
+	tm, ok := toTime(t)
+	if !ok {
+		return ""
+	}
+
+	d := time.Since(tm)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	const day = 24 * time.Hour
+
+	var n int
+	var unit string
+
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < day:
+		n, unit = int(d/time.Hour), "hour"
+	case d < 30*day:
+		n, unit = int(d/day), "day"
+	case d < 365*day:
+		n, unit = int(d/(30*day)), "month"
+	default:
+		n, unit = int(d/(365*day)), "year"
+	}
+
+	s := fmt.Sprintf("%d %s", n, pluralize(n, unit))
+	if future {
+		return "in " + s
+	}
+
+	return s + " ago"
+}
+
+func toTime(t interface{}) (time.Time, bool) {
+	switch t := t.(type) {
+	case time.Time:
+		return t, !t.IsZero()
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, false
+		}
+		return *t, !t.IsZero()
+	}
+
+	return time.Time{}, false
+}
+
+// truncate shortens s to at most n characters, ending it with an ellipsis if
+// anything was cut, e.g. {{.Body | truncate 140}}.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	return string([]rune(s)[:n]) + "…"
+}
+
+// pluralize returns singular if n is 1, otherwise plural, which defaults to
+// singular with an "s" appended:
+//
+//	{{len .Comments}} {{pluralize (len .Comments) "comment"}}
+func pluralize(n int, singular string, plural ...string) string {
+	if n == 1 {
+		return singular
+	}
+
+	if len(plural) > 0 {
+		return plural[0]
+	}
+
+	return singular + "s"
+}
+
+// defaultValue returns v, or def if v is empty, e.g.
+// {{.Name | default "Anonymous"}}.
+func defaultValue(def, v interface{}) interface{} {
+	if v == nil || reflect.ValueOf(v).IsZero() {
+		return def
+	}
+
+	return v
+}
+
+// dict builds a map from alternating keys and values, mostly for passing
+// several values to a template:
+//
+//	{{template "row" dict "user" . "admin" true}}
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict requires an even number of arguments")
+	}
+
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		k, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings, got %T", pairs[i])
+		}
+
+		m[k] = pairs[i+1]
+	}
+
+	return m, nil
+}
+
+func list(items ...interface{}) []interface{} {
+	return items
+}
+
+// toJSON encodes v for use in a script:
+//
+//	<script>var user = {{json .User}};</script>
+func toJSON(v interface{}) (template.JS, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return template.JS(data), nil
+}
+
+// safeHTML marks s as trusted HTML that shouldn't be escaped. Never use it
+// with user input.
+func safeHTML(s string) template.HTML {
+	return template.HTML(s)
+}
+
+// safeURL marks s as a trusted URL, e.g. one with a scheme html/template
+// would otherwise reject.
+func safeURL(s string) template.URL {
+	return template.URL(s)
+}
@@ -0,0 +1,204 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"bytes"
+	"github.com/gorilla/sessions"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testApp() (*Goat, fstest.MapFS) {
+	files := fstest.MapFS{
+		"templates/links.html": {Data: []byte(`{{url "user" "id" 7}} {{asset "/static/app.css"}}`)},
+		"templates/user.html":  {Data: []byte(`{{with currentUser .Context}}{{.Username}}{{end}}|{{csrfField .Context}}`)},
+		"templates/email.html": {Data: []byte(`<a href="{{absURL "user" "id" 7 (dict "ref" "email")}}">`)},
+		"static/app.css":       {Data: []byte(`body {}`)},
+	}
+
+	g := New(nil)
+	g.RegisterRoute("/users/{id}", "user", GET, func(w http.ResponseWriter, r *http.Request, c *Context) error {
+		return nil
+	})
+	g.RegisterStaticFS("/static/", files)
+
+	return g, files
+}
+
+func render(t *testing.T, c *Context, name string, data interface{}) string {
+	var buf bytes.Buffer
+	if err := c.executeTemplate(&buf, name, data); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestAppTemplateFuncs(t *testing.T) {
+	g, files := testApp()
+	if err := g.LoadTemplatesFS(files, "templates", "templates/*.html", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Context{goat: g}
+	links := render(t, c, "links.html", nil)
+	if !strings.HasPrefix(links, "/users/7 /static/app.css?v=") {
+		t.Fatalf("got %q", links)
+	}
+
+	// Templates parsed without an app are bound when registered
+	if err := g.RegisterTemplates(ParseTemplatesFS("templates", files, "templates/*.html", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := render(t, c, "links.html", nil); got != links {
+		t.Fatalf("got %q from registered templates, want %q", got, links)
+	}
+}
+
+func TestRegisterTemplatesOnSeveralApps(t *testing.T) {
+	files := fstest.MapFS{"home.html": {Data: []byte(`{{url "home"}}`)}}
+	ts := ParseTemplatesFS("templates", files, "*.html", nil, nil)
+
+	var apps []*Goat
+	for _, path := range []string{"/one", "/two"} {
+		g := New(nil)
+		g.RegisterRoute(path, "home", GET, func(w http.ResponseWriter, r *http.Request, c *Context) error {
+			return nil
+		})
+
+		if err := g.RegisterTemplates(ts); err != nil {
+			t.Fatal(err)
+		}
+
+		apps = append(apps, g)
+	}
+
+	for i, want := range []string{"/one", "/two"} {
+		if got := render(t, &Context{goat: apps[i]}, "home.html", nil); got != want {
+			t.Errorf("app %d rendered %q, want %q", i+1, got, want)
+		}
+	}
+
+	// Executed templates can't be cloned
+	ts.ExecuteTemplate(io.Discard, "home.html", nil)
+	if err := New(nil).RegisterTemplates(ts); err == nil {
+		t.Error("registering executed templates succeeded")
+	}
+}
+
+func TestAbsURLOutsideRequest(t *testing.T) {
+	g, files := testApp()
+	g.Config.BaseURL = "https://example.com/app"
+	if err := g.LoadTemplatesFS(files, "templates", "templates/*.html", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := g.ExecuteTemplate(&buf, "email.html", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `<a href="https://example.com/app/users/7?ref=email">`; buf.String() != want {
+		t.Fatalf("got %q, want %q", buf.String(), want)
+	}
+}
+
+func TestContextTemplateFuncs(t *testing.T) {
+	g, files := testApp()
+	if err := g.LoadTemplatesFS(files, "templates", "templates/*.html", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	c := &Context{
+		goat:     g,
+		request:  httptest.NewRequest("GET", "/", nil),
+		response: w,
+		Session:  sessions.NewSession(sessions.NewCookieStore([]byte("secret")), "test"),
+		User:     &User{Username: "alice"},
+	}
+
+	token, err := c.CSRFToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := render(t, c, "user.html", map[string]interface{}{"Context": c})
+	want := `alice|<input type="hidden" name="csrf_token" value="` + token + `">`
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestTextFuncs(t *testing.T) {
+	if got := truncate(5, "héllo wörld"); got != "héllo…" {
+		t.Errorf("truncate = %q", got)
+	}
+
+	if got := truncate(20, "short"); got != "short" {
+		t.Errorf("truncate = %q", got)
+	}
+
+	if got := pluralize(1, "box", "boxes"); got != "box" {
+		t.Errorf("pluralize(1) = %q", got)
+	}
+
+	if got := pluralize(2, "comment"); got != "comments" {
+		t.Errorf("pluralize(2) = %q", got)
+	}
+
+	if got := defaultValue("anonymous", ""); got != "anonymous" {
+		t.Errorf("default of empty = %v", got)
+	}
+
+	if got := defaultValue("anonymous", "bob"); got != "bob" {
+		t.Errorf("default of value = %v", got)
+	}
+
+	if _, err := dict("odd"); err == nil {
+		t.Error("dict with an odd number of arguments succeeded")
+	}
+
+	if got := timeAgo(time.Now().Add(-3 * time.Hour)); got != "3 hours ago" {
+		t.Errorf("timeAgo = %q", got)
+	}
+
+	if got := timeAgo(time.Now().Add(49 * time.Hour)); got != "in 2 days" {
+		t.Errorf("timeAgo = %q", got)
+	}
+
+	if got := date("2006-01-02", (*time.Time)(nil)); got != "" {
+		t.Errorf("date of nil = %q", got)
+	}
+}
@@ -0,0 +1,369 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// templateSet holds the templates registered on an app. Sets loaded from
+// files can be re-parsed when those files change.
+type templateSet struct {
+	mu sync.Mutex
+	t  executor
+
+	// The filesystem the files are read from, or nil for the local disk
+	fsys fs.FS
+	// Lists the files the set is parsed from; nil for sets registered
+	// already parsed
+	files func() ([]string, error)
+	parse func(files []string) (executor, error)
+
+	mtimes map[string]time.Time
+}
+
+// executor is satisfied by *template.Template and by layoutSet.
+type executor interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+type LayoutConfig struct {
+	// The directory containing the layouts, partials and pages, either on
+	// disk or within FS
+	Root string
+	// If set, templates are read from FS, such as an embed.FS, rather than
+	// from disk
+	FS fs.FS
+	// The layout every page is rendered in, relative to Root. Defaults to
+	// "layouts/base.html".
+	Layout string
+	// Directory of templates shared by every page, relative to Root.
+	// Defaults to "partials".
+	Partials string
+	// Directory of pages, relative to Root. Defaults to "pages".
+	Pages string
+	// Extension of template files, defaults to ".html"
+	Ext string
+	// Functions for these templates only, overriding the app's
+	Funcs  template.FuncMap
+	Delims []string
+}
+
+// layoutSet holds a template set per page, each made up of the layout, the
+// partials and the page itself.
+type layoutSet struct {
+	layout string
+	pages  map[string]*template.Template
+}
+
+// ExecuteTemplate renders a page by executing the layout from the page's
+// set, so that the page's definitions override the layout's blocks.
+func (l *layoutSet) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	t, ok := l.pages[name]
+	if !ok {
+		return fmt.Errorf("goat: no page named %q", name)
+	}
+
+	return t.ExecuteTemplate(w, l.layout, data)
+}
+
+// LoadLayouts loads templates from a directory tree of layouts, partials and
+// pages, and registers them for Context.Render. Every page is parsed into its
+// own set along with the layout and all of the partials, so pages can
+// override the layout's {{block}}s without their definitions colliding:
+//
+//	templates/layouts/base.html    <html>{{block "content" .}}{{end}}</html>
+//	templates/partials/nav.html    {{define "nav"}}...{{end}}
+//	templates/pages/users/show.html {{define "content"}}{{template "nav"}}...{{end}}
+//
+//	g.LoadLayouts(goat.LayoutConfig{Root: "templates"})
+//	c.Render("users/show.html", user)
+//
+// Pages are named by their path relative to the pages directory. Like
+// LoadTemplates, the tree is re-parsed when it changes if Config.Development
+// is set.
+func (g *Goat) LoadLayouts(cfg LayoutConfig) error {
+	if cfg.Layout == "" {
+		cfg.Layout = "layouts/base.html"
+	}
+
+	if cfg.Partials == "" {
+		cfg.Partials = "partials"
+	}
+
+	if cfg.Pages == "" {
+		cfg.Pages = "pages"
+	}
+
+	if cfg.Ext == "" {
+		cfg.Ext = ".html"
+	}
+
+	// Templates on disk are read through the same fs.FS interface, rooted
+	// at Root
+	if cfg.Root == "" {
+		cfg.Root = "."
+	}
+
+	fsys := cfg.FS
+	if fsys == nil {
+		fsys = os.DirFS(cfg.Root)
+	} else if cfg.Root != "." {
+		var err error
+		if fsys, err = fs.Sub(fsys, cfg.Root); err != nil {
+			return err
+		}
+	}
+
+	layout := path.Clean(cfg.Layout)
+	partials := path.Clean(cfg.Partials)
+	pages := path.Clean(cfg.Pages)
+
+	s := &templateSet{
+		fsys: fsys,
+		files: func() ([]string, error) {
+			p, err := walkTemplates(fsys, partials, cfg.Ext)
+			if err != nil {
+				return nil, err
+			}
+
+			pg, err := walkTemplates(fsys, pages, cfg.Ext)
+			if err != nil {
+				return nil, err
+			}
+
+			return append(append([]string{layout}, p...), pg...), nil
+		},
+		parse: func(files []string) (executor, error) {
+			base := template.New(path.Base(layout)).Funcs(g.TemplateFuncs()).Funcs(cfg.Funcs)
+			if cfg.Delims != nil {
+				base.Delims(cfg.Delims[0], cfg.Delims[1])
+			}
+
+			var pageFiles []string
+			for _, f := range files[1:] {
+				if strings.HasPrefix(f, pages+"/") {
+					pageFiles = append(pageFiles, f)
+				} else if _, err := parseFiles(base, fsys, f); err != nil {
+					return nil, err
+				}
+			}
+
+			if _, err := parseFiles(base, fsys, layout); err != nil {
+				return nil, err
+			}
+
+			set := &layoutSet{
+				layout: path.Base(layout),
+				pages:  make(map[string]*template.Template),
+			}
+
+			for _, f := range pageFiles {
+				t, err := base.Clone()
+				if err != nil {
+					return nil, err
+				}
+
+				if _, err = parseFiles(t, fsys, f); err != nil {
+					return nil, err
+				}
+
+				set.pages[strings.TrimPrefix(f, pages+"/")] = t
+			}
+
+			return set, nil
+		},
+	}
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	g.templates = s
+
+	return nil
+}
+
+// walkTemplates lists the files with the given extension under dir. A
+// missing directory has no templates.
+func walkTemplates(fsys fs.FS, dir, ext string) (files []string, err error) {
+	err = fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == dir {
+			return fs.SkipDir
+		} else if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && path.Ext(p) == ext {
+			files = append(files, p)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// LoadTemplates parses the templates matching a glob and registers them for
+// Context.Render, returning an error rather than panicking if they can't be
+// parsed. funcs and delims are optional, as with ParseTemplates.
+//
+// In production the templates are parsed once. When Config.Development is
+// set, the files are checked on every render and re-parsed if any have been
+// added, removed or modified.
+func (g *Goat) LoadTemplates(name, pattern string, funcs template.FuncMap, delims []string) error {
+	return g.loadTemplates(nil, name, pattern, funcs, delims)
+}
+
+// LoadTemplatesFS is like LoadTemplates, but reads the templates from fsys,
+// such as an embed.FS.
+func (g *Goat) LoadTemplatesFS(fsys fs.FS, name, pattern string, funcs template.FuncMap, delims []string) error {
+	return g.loadTemplates(fsys, name, pattern, funcs, delims)
+}
+
+func (g *Goat) loadTemplates(fsys fs.FS, name, pattern string, funcs template.FuncMap, delims []string) error {
+	s := &templateSet{
+		fsys: fsys,
+		files: func() ([]string, error) {
+			if fsys == nil {
+				return filepath.Glob(pattern)
+			}
+
+			return fs.Glob(fsys, pattern)
+		},
+		parse: func(files []string) (executor, error) {
+			if len(files) == 0 {
+				return nil, fmt.Errorf("goat: pattern matches no files: %#q", pattern)
+			}
+
+			t := template.New(name).Funcs(g.TemplateFuncs()).Funcs(funcs)
+			if delims != nil {
+				t.Delims(delims[0], delims[1])
+			}
+
+			return parseFiles(t, fsys, files...)
+		},
+	}
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	g.templates = s
+
+	return nil
+}
+
+// template returns the parsed templates, first re-parsing them if reload is
+// set and their files have changed. If re-parsing fails the error is
+// returned, so that it shows up on the page being worked on.
+func (s *templateSet) template(reload bool) (executor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if reload && s.files != nil && s.stale() {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.t, nil
+}
+
+func (s *templateSet) load() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	t, err := s.parse(files)
+	if err != nil {
+		return err
+	}
+
+	s.t = t
+	s.mtimes = s.modTimes(files)
+
+	return nil
+}
+
+// stale reports whether the set's files have changed since it was parsed.
+// Files without modification times, such as those in an embed.FS, never
+// change.
+func (s *templateSet) stale() bool {
+	files, err := s.files()
+	if err != nil || len(files) != len(s.mtimes) {
+		return true
+	}
+
+	for f, mtime := range s.modTimes(files) {
+		if last, ok := s.mtimes[f]; !ok || !mtime.Equal(last) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *templateSet) modTimes(files []string) map[string]time.Time {
+	mtimes := make(map[string]time.Time, len(files))
+
+	for _, f := range files {
+		var info fs.FileInfo
+		var err error
+
+		if s.fsys == nil {
+			info, err = os.Stat(f)
+		} else {
+			info, err = fs.Stat(s.fsys, f)
+		}
+
+		if err == nil {
+			mtimes[f] = info.ModTime()
+		}
+	}
+
+	return mtimes
+}
+
+// parseFiles parses files into t from fsys, or from disk if fsys is nil.
+func parseFiles(t *template.Template, fsys fs.FS, files ...string) (*template.Template, error) {
+	if fsys == nil {
+		return t.ParseFiles(files...)
+	}
+
+	return t.ParseFS(fsys, files...)
+}
@@ -0,0 +1,67 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadLayouts(t *testing.T) {
+	files := fstest.MapFS{
+		"templates/layouts/base.html":     {Data: []byte(`<title>{{block "title" .}}Site{{end}}</title>{{template "nav"}}{{block "content" .}}{{end}}`)},
+		"templates/partials/nav.html":     {Data: []byte(`{{define "nav"}}<nav>{{end}}`)},
+		"templates/pages/index.html":      {Data: []byte(`{{define "content"}}home{{end}}`)},
+		"templates/pages/users/show.html": {Data: []byte(`{{define "title"}}{{.}}{{end}}{{define "content"}}user {{.}}{{end}}`)},
+	}
+
+	g := New(nil)
+	if err := g.LoadLayouts(LayoutConfig{Root: "templates", FS: files}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Context{goat: g}
+
+	// Each page overrides the layout's blocks without affecting the others
+	tests := map[string]string{
+		"index.html":      `<title>Site</title><nav>home`,
+		"users/show.html": `<title>alice</title><nav>user alice`,
+	}
+
+	for page, want := range tests {
+		if got := render(t, c, page, "alice"); got != want {
+			t.Errorf("%s rendered %q, want %q", page, got, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := c.executeTemplate(&buf, "missing.html", nil); err == nil || !strings.Contains(err.Error(), `no page named "missing.html"`) {
+		t.Errorf("rendering a missing page returned %v", err)
+	}
+}
@@ -28,21 +28,45 @@
 package goat
 
 import (
-	"code.google.com/p/go.crypto/bcrypt"
-	"crypto/md5"
 	"errors"
-	"fmt"
-	"io"
+	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
+	"log"
 	"net/http"
+	"reflect"
+	"strings"
 	"time"
 )
 
+var (
+	ErrUserExists   = errors.New("account with that name already exists")
+	ErrEmailExists  = errors.New("account with that email already exists")
+	ErrNoEmail      = errors.New("account has no email address")
+	ErrTokenExpired = errors.New("token expired")
+	ErrEmailChanged = errors.New("email address changed since the token was issued")
+
+	ErrAccountDisabled = errors.New("account is disabled")
+)
+
+const (
+	// Token purposes. Password reset tokens leave it empty, as they did
+	// before tokens had a purpose.
+	tokenPasswordReset = ""
+	tokenVerifyEmail   = "verify_email"
+
+	tokenExpiry = 48 * time.Hour
+)
+
+// ResetToken is a single use token that expires after 48 hours, used both to
+// reset passwords and to verify email addresses.
 type ResetToken struct {
 	Id        bson.ObjectId
 	Username  string
 	Token     string
+	Purpose   string
 	Timestamp time.Time
+	// The address a verification token was sent to
+	Email string `bson:",omitempty"`
 }
 
 func (r *ResetToken) Delete(c *Context) error {
@@ -55,16 +79,80 @@ func (r *ResetToken) Save(c *Context) error {
 }
 
 type User struct {
-	Id       bson.ObjectId          `json:"-" bson:"_id,omitempty"`
-	Username string                 `json:"username,omitempty" bson:"username,omitempty"`
-	Password []byte                 `json:"-" bson:"password,omitempty"`
-	Values   map[string]interface{} `json:"values,omitempty" bson:"values,omitempty"`
+	Id                 bson.ObjectId          `json:"-" bson:"_id,omitempty"`
+	Username           string                 `json:"username,omitempty" bson:"username,omitempty"`
+	NormalizedUsername string                 `json:"-" bson:"username_normalized,omitempty"`
+	Password           []byte                 `json:"-" bson:"password,omitempty"`
+	Roles              []string               `json:"roles,omitempty" bson:"roles,omitempty"`
+	Permissions        []string               `json:"permissions,omitempty" bson:"permissions,omitempty"`
+	Email              string                 `json:"email,omitempty" bson:"email,omitempty"`
+	Verified           bool                   `json:"verified" bson:"verified"`
+	Disabled           bool                   `json:"disabled,omitempty" bson:"disabled,omitempty"`
+	DeletedAt          *time.Time             `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	Values             map[string]interface{} `json:"values,omitempty" bson:"values,omitempty"`
+	Profile            interface{}            `json:"profile,omitempty" bson:"profile,omitempty"`
+}
+
+// ProfileValidator can be implemented by a profile to have it validated
+// every time the user is saved.
+type ProfileValidator interface {
+	Validate() error
+}
+
+// RegisterProfile sets the struct that users of this app keep their
+// app-specific fields in, instead of Values. Users loaded by FindUser,
+// FindUserByEmail, Authenticate and NewAuthSessionInterceptor have their
+// Profile decoded into a pointer to a new value of that type:
+//
+//	g.RegisterProfile(Profile{})
+//	...
+//	p := c.User.Profile.(*Profile)
+func (g *Goat) RegisterProfile(v interface{}) {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	g.profile = t
+}
+
+// DecodeProfile decodes the user's profile into out, which should be a
+// pointer to a struct.
+func (u *User) DecodeProfile(out interface{}) error {
+	if u.Profile == nil {
+		return nil
+	}
+
+	data, err := bson.Marshal(u.Profile)
+	if err != nil {
+		return err
+	}
+
+	return bson.Unmarshal(data, out)
 }
 
 // SetPassword takes a plaintext password and hashes it with bcrypt and sets the
-// password field to the hash.
+// password field to the hash. The password is checked against
+// DefaultPasswordPolicy; use ChangePassword to enforce the app's policy.
 func (u *User) SetPassword(password string) error {
-	hpass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	p := DefaultPasswordPolicy
+	return u.setPassword(password, p, BcryptHasher{Cost: p.cost()})
+}
+
+// ChangePassword validates a plaintext password against the password policy
+// of the app serving the request, then hashes it with the app's
+// PasswordHasher and sets the password field to the hash. Policy failures are
+// returned as a *PasswordError.
+func (u *User) ChangePassword(password string, c *Context) error {
+	return u.setPassword(password, c.passwordPolicy(), c.passwordHasher())
+}
+
+func (u *User) setPassword(password string, p *PasswordPolicy, h PasswordHasher) error {
+	if err := p.Validate(u.Username, password); err != nil {
+		return err
+	}
+
+	hpass, err := h.Hash([]byte(password))
 	if err != nil {
 		return err
 	}
@@ -74,21 +162,190 @@ func (u *User) SetPassword(password string) error {
 	return nil
 }
 
+// HasRole reports whether the user has been given any of the provided roles.
+func (u *User) HasRole(roles ...string) bool {
+	return containsAny(u.Roles, roles)
+}
+
+// HasPermission reports whether the user has been granted every one of the
+// provided permissions.
+func (u *User) HasPermission(permissions ...string) bool {
+	for _, p := range permissions {
+		if !containsAny(u.Permissions, []string{p}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// outranks reports whether the user holds every role and permission of
+// other, and so can't gain any privileges by acting on their account.
+func (u *User) outranks(other *User) bool {
+	for _, role := range other.Roles {
+		if !u.HasRole(role) {
+			return false
+		}
+	}
+
+	return u.HasPermission(other.Permissions...)
+}
+
+// AddRole adds a role to the user. The change is persisted on the next Save.
+func (u *User) AddRole(role string) {
+	if !u.HasRole(role) {
+		u.Roles = append(u.Roles, role)
+	}
+}
+
+func (u *User) RemoveRole(role string) {
+	u.Roles = remove(u.Roles, role)
+}
+
+// Grant gives the user a permission. The change is persisted on the next Save.
+func (u *User) Grant(permission string) {
+	if !u.HasPermission(permission) {
+		u.Permissions = append(u.Permissions, permission)
+	}
+}
+
+func (u *User) Revoke(permission string) {
+	u.Permissions = remove(u.Permissions, permission)
+}
+
+// SetEmail changes the user's email address. A new address needs to be
+// verified again. The change is persisted on the next Save, which returns
+// ErrEmailExists if the address belongs to another account.
+func (u *User) SetEmail(email string) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	if email != u.Email {
+		u.Email = email
+		u.Verified = false
+	}
+}
+
+// Save persists the user and keeps NormalizedUsername, which goat_users is
+// uniquely indexed on, up to date. If another account already has the same
+// normalized username ErrUserExists is returned.
 func (u *User) Save(c *Context) (err error) {
+	if v, ok := u.Profile.(ProfileValidator); ok {
+		if err = v.Validate(); err != nil {
+			return
+		}
+	}
+
+	u.NormalizedUsername = NormalizeUsername(u.Username)
 	_, err = c.Database.C("goat_users").UpsertId(u.Id, u)
 
+	return userError(err)
+}
+
+// Active reports whether the user is allowed to log in, meaning they have
+// been neither disabled nor deleted.
+func (u *User) Active() bool {
+	return !u.Disabled && u.DeletedAt == nil
+}
+
+// Disable stops the user from logging in until they are enabled again.
+func (u *User) Disable(c *Context) error {
+	u.Disabled = true
+	return u.Save(c)
+}
+
+func (u *User) Enable(c *Context) error {
+	u.Disabled = false
+	return u.Save(c)
+}
+
+// Delete soft-deletes the user. They can no longer log in or be found with
+// FindUser, but the account can be restored until it's purged by
+// PurgeDeletedUsers.
+func (u *User) Delete(c *Context) error {
+	now := time.Now()
+	u.DeletedAt = &now
+
+	return u.Save(c)
+}
+
+func (u *User) Restore(c *Context) error {
+	u.DeletedAt = nil
+	return u.Save(c)
+}
+
+// Purge permanently removes the user along with any tokens issued to them.
+func (u *User) Purge(c *Context) error {
+	if err := c.Database.C("goat_users").RemoveId(u.Id); err != nil {
+		return err
+	}
+
+	if _, err := c.Database.C("goat_reset_tokens").RemoveAll(bson.M{"username": u.Username}); err != nil {
+		return err
+	}
+
+	return u.RevokeRememberTokens(c)
+}
+
+// ChangeUsername renames the user, moving any outstanding tokens over to the
+// new name. ErrUserExists is returned if the name is already taken.
+func (u *User) ChangeUsername(username string, c *Context) error {
+	old := u.Username
+	if username == old {
+		return nil
+	}
+
+	u.Username = username
+	if err := u.Save(c); err != nil {
+		u.Username = old
+		return err
+	}
+
+	_, err := c.Database.C("goat_reset_tokens").UpdateAll(
+		bson.M{"username": old},
+		bson.M{"$set": bson.M{"username": username}},
+	)
+
+	return err
+}
+
+// PurgeDeletedUsers permanently removes the users that were soft-deleted
+// more than retention ago, returning how many were purged.
+func PurgeDeletedUsers(retention time.Duration, c *Context) (n int, err error) {
+	iter := c.Database.C("goat_users").Find(bson.M{
+		"deleted_at": bson.M{"$lt": time.Now().Add(-retention)},
+	}).Iter()
+
+	for {
+		var u User
+		if !iter.Next(&u) {
+			break
+		}
+
+		if err = u.Purge(c); err != nil {
+			iter.Close()
+			return
+		}
+		n++
+	}
+
+	err = iter.Close()
+
 	return
 }
 
 func (u *User) Login(w http.ResponseWriter, r *http.Request, c *Context) {
 	c.Session.Values["uid"] = u.Id
 	c.Session.Save(r, w)
+
+	c.audit(AuditLogin, u.Username, AuditSuccess, "")
 }
 
+// NewUser builds a user with the given credentials, but doesn't save it. Use
+// CreateUser to create the account in one step.
 func NewUser(username, password string, c *Context) (u *User, err error) {
-	query := c.Database.C("goat_users").Find(bson.M{"username": bson.M{"$in": []string{username}}})
+	query := c.Database.C("goat_users").Find(usernameQuery(username))
 	if n, _ := query.Count(); n > 0 {
-		return nil, errors.New("account with that name already exists")
+		return nil, ErrUserExists
 	}
 
 	u = &User{
@@ -96,48 +353,274 @@ func NewUser(username, password string, c *Context) (u *User, err error) {
 		Username: username,
 		Values:   make(map[string]interface{}),
 	}
-	err = u.SetPassword(password)
+	if err = u.ChangePassword(password, c); err != nil {
+		return nil, err
+	}
 
 	return
 }
 
+// CreateUser builds a new user with the given roles and inserts it. The
+// unique index on goat_users makes this safe against concurrent signups for
+// the same name, in which case ErrUserExists is returned.
+func CreateUser(username, password string, c *Context, roles ...string) (*User, error) {
+	u, err := NewUser(username, password, c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		u.AddRole(role)
+	}
+
+	if err = u.insert(c); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// insert saves a new user, failing rather than overwriting an existing
+// account.
+func (u *User) insert(c *Context) error {
+	if v, ok := u.Profile.(ProfileValidator); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+
+	u.NormalizedUsername = NormalizeUsername(u.Username)
+
+	return userError(c.Database.C("goat_users").Insert(u))
+}
+
+// FindUser looks up a user by username. Deleted users aren't returned.
 func FindUser(username string, c *Context) (u *User, err error) {
-	err = c.Database.C("goat_users").Find(bson.M{
-		"username": username,
-	}).One(&u)
+	if err = c.Database.C("goat_users").Find(notDeleted(usernameQuery(username))).One(&u); err != nil {
+		return
+	}
+
+	err = c.decodeProfile(u)
 
 	return
 }
 
+// FindUserIncludingDeleted looks up a user by username whether or not they
+// have been deleted, for managing accounts that may need restoring or
+// purging.
+func FindUserIncludingDeleted(username string, c *Context) (u *User, err error) {
+	if err = c.Database.C("goat_users").Find(usernameQuery(username)).One(&u); err != nil {
+		return
+	}
+
+	err = c.decodeProfile(u)
+
+	return
+}
+
+func FindUserByEmail(email string, c *Context) (u *User, err error) {
+	err = c.Database.C("goat_users").Find(notDeleted(bson.M{
+		"email": strings.ToLower(strings.TrimSpace(email)),
+	})).One(&u)
+	if err != nil {
+		return
+	}
+
+	err = c.decodeProfile(u)
+
+	return
+}
+
+// NormalizeUsername returns the form of a username used to check for
+// uniqueness, so that "Alice" and "alice" are the same account.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// UsernameCollisionError is returned by EnsureUserIndexes when accounts
+// saved before usernames were normalized clash with other accounts, e.g.
+// "Alice" and "alice". They can't be found or saved until they are renamed
+// in the database.
+type UsernameCollisionError struct {
+	Usernames []string
+}
+
+func (e *UsernameCollisionError) Error() string {
+	return "goat: these usernames clash with other accounts when normalized and must be renamed: " +
+		strings.Join(e.Usernames, ", ")
+}
+
+// EnsureUserIndexes creates the indexes goat relies on for users, and
+// normalizes the usernames of accounts saved before usernames were
+// normalized. It's called by NewDatabaseMiddleware when the app starts.
+// Accounts that can't be normalized are reported with a
+// *UsernameCollisionError once everything else is done.
+func EnsureUserIndexes(db *mgo.Database) error {
+	for _, key := range []string{"username_normalized", "email"} {
+		err := db.C("goat_users").EnsureIndex(mgo.Index{
+			Key:        []string{key},
+			Unique:     true,
+			Sparse:     true,
+			Background: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := ensureRememberIndexes(db); err != nil {
+		return err
+	}
+
+	return normalizeUsernames(db)
+}
+
+// normalizeUsernames backfills username_normalized. The unique index makes
+// any account that clashes with another fail.
+func normalizeUsernames(db *mgo.Database) error {
+	var legacy []User
+	err := db.C("goat_users").Find(bson.M{"username_normalized": bson.M{"$exists": false}}).
+		Select(bson.M{"username": 1}).All(&legacy)
+	if err != nil {
+		return err
+	}
+
+	var collisions []string
+
+	for _, u := range legacy {
+		err := db.C("goat_users").UpdateId(u.Id, bson.M{
+			"$set": bson.M{"username_normalized": NormalizeUsername(u.Username)},
+		})
+		if mgo.IsDup(err) {
+			collisions = append(collisions, u.Username)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(collisions) > 0 {
+		return &UsernameCollisionError{Usernames: collisions}
+	}
+
+	return nil
+}
+
+func usernameQuery(username string) bson.M {
+	return bson.M{"username_normalized": NormalizeUsername(username)}
+}
+
+func notDeleted(query bson.M) bson.M {
+	query["deleted_at"] = bson.M{"$exists": false}
+	return query
+}
+
+func userError(err error) error {
+	if mgo.IsDup(err) {
+		if dupIndex(err) == "email_1" {
+			return ErrEmailExists
+		}
+
+		return ErrUserExists
+	}
+
+	return err
+}
+
+// dupIndex returns the name of the index a duplicate key error violated.
+// The message names it as "index: db.collection.$name" or, in later MongoDB
+// versions, "index: name", followed by the duplicate value.
+func dupIndex(err error) string {
+	msg := err.Error()
+
+	i := strings.Index(msg, "index: ")
+	if i < 0 {
+		return ""
+	}
+
+	fields := strings.Fields(msg[i+len("index: "):])
+	if len(fields) == 0 {
+		return ""
+	}
+
+	name := fields[0]
+	if j := strings.LastIndex(name, "$"); j >= 0 {
+		name = name[j+1:]
+	}
+
+	return name
+}
+
 // Login validates and returns a user object if they exist in the database.
+// Passwords stored with an outdated algorithm or cost are re-hashed with the
+// app's PasswordHasher on success. If lockout is enabled on the app,
+// ErrAccountLocked is returned while the username or the client IP is locked
+// out, and ErrAccountDisabled is returned for disabled users.
 func Authenticate(username, password string, c *Context) (u *User, err error) {
-	if err = c.Database.C("goat_users").Find(bson.M{"username": username}).One(&u); err != nil {
+	defer func() {
+		if err != nil {
+			c.audit(AuditLogin, username, AuditFailure, err.Error())
+		}
+	}()
+
+	l := c.lockout()
+	if err = l.check(username, c); err != nil {
 		return
 	}
 
-	if err = bcrypt.CompareHashAndPassword(u.Password, []byte(password)); err != nil {
+	if u, err = FindUser(username, c); err != nil {
+		l.fail(username, c)
+		return
+	}
+
+	if err = ComparePassword(u.Password, password); err != nil {
 		u = nil
+		l.fail(username, c)
+		return
+	}
+
+	// Only reveal that the account is disabled to someone who knows the
+	// password
+	if !u.Active() {
+		return nil, ErrAccountDisabled
+	}
+
+	l.succeed(username, c)
+
+	// Upgrade the stored hash while we have the plaintext password
+	if h := c.passwordHasher(); needsRehash(h, u.Password) {
+		// Only the hash is written so a concurrent change to the account,
+		// like disabling it, isn't overwritten
+		hpass, herr := h.Hash([]byte(password))
+		if herr == nil {
+			herr = c.Database.C("goat_users").UpdateId(u.Id, bson.M{"$set": bson.M{"password": hpass}})
+		}
+
+		if herr != nil {
+			log.Printf("goat: upgrading the password hash of %s: %v", u.Username, herr)
+		} else {
+			u.Password = hpass
+		}
 	}
 
 	return
 }
 
-func ResetPassword(token, password string, c *Context) error {
+func ResetPassword(token, password string, c *Context) (err error) {
+	var username string
+	defer func() {
+		if err != nil {
+			c.audit(AuditPasswordReset, username, AuditFailure, err.Error())
+		} else {
+			c.audit(AuditPasswordReset, username, AuditSuccess, "")
+		}
+	}()
+
 	// Get the reset token record
-	var reset ResetToken
-	err := c.Database.C("goat_reset_tokens").Find(
-		bson.M{
-			"token": token,
-		}).One(&reset)
+	reset, err := findToken(token, tokenPasswordReset, c)
 	if err != nil {
 		return err
 	}
-
-	// Token expiry is 48 hours
-	if time.Since(reset.Timestamp) > (48 * time.Hour) {
-		reset.Delete(c)
-		return errors.New("token expired")
-	}
+	username = reset.Username
 
 	// Get the user
 	u, err := FindUser(reset.Username, c)
@@ -145,7 +628,11 @@ func ResetPassword(token, password string, c *Context) error {
 		return err
 	}
 
-	err = u.SetPassword(password)
+	if !u.Active() {
+		return ErrAccountDisabled
+	}
+
+	err = u.ChangePassword(password, c)
 	if err != nil {
 		return err
 	}
@@ -155,31 +642,137 @@ func ResetPassword(token, password string, c *Context) error {
 		return err
 	}
 
+	// Whoever knew the old password may have asked to be remembered
+	err = u.RevokeRememberTokens(c)
+	if err != nil {
+		return err
+	}
+
 	return u.Save(c)
 }
 
 // Fetches a request token for the user. If the user is found,
 // they will be added to the provided context.
-func RequestResetToken(username string, c *Context) (*ResetToken, error) {
+func RequestResetToken(username string, c *Context) (t *ResetToken, err error) {
+	defer func() {
+		if err != nil {
+			c.audit(AuditResetRequested, username, AuditFailure, err.Error())
+		} else {
+			c.audit(AuditResetRequested, username, AuditSuccess, "")
+		}
+	}()
+
 	u, err := FindUser(username, c)
 	if err != nil {
 		return nil, err
 	}
+
+	if !u.Active() {
+		return nil, ErrAccountDisabled
+	}
 	c.User = u
 
+	return newToken(u.Username, "", tokenPasswordReset, c)
+}
+
+// RequestVerificationToken creates a token that can be sent to the user's
+// email address and passed back to VerifyEmail.
+func RequestVerificationToken(u *User, c *Context) (*ResetToken, error) {
+	if u.Email == "" {
+		return nil, ErrNoEmail
+	}
+
+	return newToken(u.Username, u.Email, tokenVerifyEmail, c)
+}
+
+// VerifyEmail marks the user that a verification token was issued for as
+// verified. The token can only be used once, and only while the user still
+// has the address it was sent to, otherwise ErrEmailChanged is returned.
+func VerifyEmail(token string, c *Context) (*User, error) {
+	verify, err := findToken(token, tokenVerifyEmail, c)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := FindUser(verify.Username, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verify.Delete(c); err != nil {
+		return nil, err
+	}
+
+	if verify.Email == "" || verify.Email != u.Email {
+		return nil, ErrEmailChanged
+	}
+
+	u.Verified = true
+
+	return u, u.Save(c)
+}
+
+func newToken(username, email, purpose string, c *Context) (*ResetToken, error) {
 	token := ResetToken{
 		Id:        bson.NewObjectId(),
-		Username:  u.Username,
+		Username:  username,
+		Purpose:   purpose,
 		Timestamp: time.Now(),
+		Email:     email,
 	}
 
-	hash := md5.New()
-	io.WriteString(hash, token.Id.Hex())
-	io.WriteString(hash, token.Timestamp.String())
-
-	token.Token = fmt.Sprintf("%x", hash.Sum(nil))
+	var err error
+	if token.Token, err = randomToken(32); err != nil {
+		return nil, err
+	}
 
-	token.Save(c)
+	if err = token.Save(c); err != nil {
+		return nil, err
+	}
 
 	return &token, nil
 }
+
+// findToken looks up an unexpired token issued for the given purpose.
+// Expired tokens are deleted.
+func findToken(token, purpose string, c *Context) (*ResetToken, error) {
+	query := bson.M{"token": token, "purpose": purpose}
+	if purpose == tokenPasswordReset {
+		// Reset tokens from before tokens had a purpose have no field
+		query["purpose"] = bson.M{"$in": []interface{}{purpose, nil}}
+	}
+
+	var t ResetToken
+	if err := c.Database.C("goat_reset_tokens").Find(query).One(&t); err != nil {
+		return nil, err
+	}
+
+	if time.Since(t.Timestamp) > tokenExpiry {
+		t.Delete(c)
+		return nil, ErrTokenExpired
+	}
+
+	return &t, nil
+}
+
+func containsAny(list, values []string) bool {
+	for _, l := range list {
+		for _, v := range values {
+			if l == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func remove(list []string, value string) (r []string) {
+	for _, l := range list {
+		if l != value {
+			r = append(r, l)
+		}
+	}
+
+	return
+}
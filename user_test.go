@@ -0,0 +1,50 @@
+/****************************************************************************
+ * Copyright (c) 2013, Scott Ferguson
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions are met:
+ *     * Redistributions of source code must retain the above copyright
+ *       notice, this list of conditions and the following disclaimer.
+ *     * Redistributions in binary form must reproduce the above copyright
+ *       notice, this list of conditions and the following disclaimer in the
+ *       documentation and/or other materials provided with the distribution.
+ *     * Neither the name of the software nor the
+ *       names of its contributors may be used to endorse or promote products
+ *       derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY SCOTT FERGUSON ''AS IS'' AND ANY
+ * EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+ * WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL SCOTT FERGUSON BE LIABLE FOR ANY
+ * DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+ * LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ * ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+ * (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+ * SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ ****************************************************************************/
+package goat
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDupIndex(t *testing.T) {
+	tests := []struct {
+		msg   string
+		index string
+	}{
+		{`E11000 duplicate key error index: emailapp.goat_users.$username_normalized_1  dup key: { : "emailbob" }`, "username_normalized_1"},
+		{`E11000 duplicate key error index: app.goat_users.$email_1  dup key: { : "bob@example.com" }`, "email_1"},
+		{`E11000 duplicate key error collection: app.goat_users index: email_1 dup key: { email: "bob@example.com" }`, "email_1"},
+		{`E11000 duplicate key error`, ""},
+	}
+
+	for _, test := range tests {
+		if index := dupIndex(errors.New(test.msg)); index != test.index {
+			t.Errorf("dupIndex(%q) = %q, want %q", test.msg, index, test.index)
+		}
+	}
+}